@@ -0,0 +1,372 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/updateagent"
+
+	"github.com/eclipse-kanto/update-manager/mqtt"
+)
+
+// environmentPrefix is the prefix of the environment variables matching the flags, e.g. FILE_AGENT_MQTT_BROKER for -mqtt-broker
+const environmentPrefix = "FILE_AGENT_"
+
+// config is the configuration of the agent.
+// It is read from the defaults, the configuration file, the environment variables and the command line flags, in increasing order of precedence.
+type config struct {
+	file       string
+	logLevel   slog.Level
+	domainName string
+	domains    domainsFlag
+	connection *mqtt.ConnectionConfig
+}
+
+// fileConfig is the layout of the JSON configuration file.
+// Its fields point to the variables the flags are bound to, so that only the values present in the file are overridden.
+type fileConfig struct {
+	LogLevel        *slog.Level            `json:"logLevel"`
+	Domain          *string                `json:"domain"`
+	Directory       *string                `json:"directory"`
+	KeepGenerations *int                   `json:"keepGenerations"`
+	DirectoryQuota  *int64                 `json:"directoryQuota"`
+	WatchDebounce   *duration              `json:"watchDebounce"`
+	Reconcile       *reconcileConfig       `json:"reconcile"`
+	Signatures      *signaturesConfig      `json:"signatures"`
+	CredentialsDir  *string                `json:"credentialsDirectory"`
+	Connection      *mqtt.ConnectionConfig `json:"connection"`
+	Download        *downloadConfig        `json:"download"`
+	Archives        *archivesConfig        `json:"archives"`
+	// Domains holds the options of the managed domains, the same as the ones of the -domain flag
+	Domains []map[string]interface{} `json:"domains"`
+}
+
+type archivesConfig struct {
+	MaxSize    *int64 `json:"maxSize"`
+	MaxEntries *int   `json:"maxEntries"`
+}
+
+type reconcileConfig struct {
+	Interval *duration `json:"interval"`
+	Policy   *string   `json:"policy"`
+}
+
+type signaturesConfig struct {
+	TrustStore *string `json:"trustStore"`
+	Required   *bool   `json:"required"`
+}
+
+type downloadConfig struct {
+	Concurrency    *int            `json:"concurrency"`
+	MaxSize        *int64          `json:"maxSize"`
+	BandwidthLimit *int64          `json:"bandwidthLimit"`
+	Windows        *string         `json:"windows"`
+	Retry          *retryConfig    `json:"retry"`
+	Timeouts       *timeoutsConfig `json:"timeouts"`
+	Progress       *progressConfig `json:"progress"`
+	Cache          *cacheConfig    `json:"cache"`
+	S3             *s3Config       `json:"s3"`
+	OCIPlainHTTP   *bool           `json:"ociPlainHttp"`
+	FileSourceDirs *string         `json:"fileSourceDirs"`
+}
+
+type s3Config struct {
+	Endpoint  *string `json:"endpoint"`
+	Region    *string `json:"region"`
+	PathStyle *bool   `json:"pathStyle"`
+}
+
+type retryConfig struct {
+	Attempts      *int      `json:"attempts"`
+	InitialDelay  *duration `json:"initialDelay"`
+	MaxDelay      *duration `json:"maxDelay"`
+	Jitter        *float64  `json:"jitter"`
+	MaxRetryAfter *duration `json:"maxRetryAfter"`
+}
+
+type timeoutsConfig struct {
+	Connect        *duration `json:"connect"`
+	ResponseHeader *duration `json:"responseHeader"`
+	IdleRead       *duration `json:"idleRead"`
+}
+
+type cacheConfig struct {
+	Directory *string `json:"directory"`
+	Size      *int64  `json:"size"`
+}
+
+type progressConfig struct {
+	Interval *duration `json:"interval"`
+	Step     *int      `json:"step"`
+}
+
+// duration is a time.Duration, which is written as a string like "5s" in the configuration file
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// domainsFlag collects the options of the repeatable -domain flag. The domain configurations are created once all flags are set,
+// so that the domain options default to the values of the corresponding flags regardless of their order.
+type domainsFlag []map[string]string
+
+func (f *domainsFlag) String() string {
+	domains := make([]string, len(*f))
+	for i, options := range *f {
+		pairs := []string{}
+		for key, value := range options {
+			if strings.Contains(value, ",") {
+				value = `"` + value + `"`
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		domains[i] = strings.Join(pairs, ",")
+	}
+	return strings.Join(domains, " ")
+}
+
+func (f *domainsFlag) Set(value string) error {
+	options, err := updateagent.ParseDomainOptions(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, options)
+	return nil
+}
+
+// registerFlags binds the flags of the agent to the given configuration and the configuration variables of the updateagent package
+func registerFlags(cfg *config) {
+	flag.StringVar(&cfg.file, "config", "", "the path to a JSON configuration file, its values are overridden by the environment variables and the flags")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelDebug, "the log level, one of DEBUG, INFO, WARN or ERROR")
+	flag.StringVar(&cfg.domainName, "domain-name", "files", "the name of the domain managed in the directory set with -dir")
+
+	flag.StringVar(&cfg.connection.BrokerURL, "mqtt-broker", cfg.connection.BrokerURL, "the URL of the MQTT broker, use ssl:// for TLS connections")
+	flag.StringVar(&cfg.connection.ClientUsername, "mqtt-username", cfg.connection.ClientUsername, "the username for the MQTT broker")
+	flag.StringVar(&cfg.connection.ClientPassword, "mqtt-password", cfg.connection.ClientPassword, "the password for the MQTT broker")
+	flag.StringVar(&cfg.connection.CACert, "mqtt-ca-cert", cfg.connection.CACert, "the path to the PEM encoded CA certificates trusted for the TLS connection to the MQTT broker")
+	flag.StringVar(&cfg.connection.Cert, "mqtt-cert", cfg.connection.Cert, "the path to the PEM encoded client certificate for the TLS connection to the MQTT broker")
+	flag.StringVar(&cfg.connection.Key, "mqtt-key", cfg.connection.Key, "the path to the PEM encoded private key of the client certificate")
+	flag.StringVar(&cfg.connection.KeepAlive, "mqtt-keep-alive", cfg.connection.KeepAlive, "the keep alive interval of the MQTT connection")
+	flag.StringVar(&cfg.connection.ConnectTimeout, "mqtt-connect-timeout", cfg.connection.ConnectTimeout, "the timeout for connecting to the MQTT broker")
+	flag.StringVar(&cfg.connection.DisconnectTimeout, "mqtt-disconnect-timeout", cfg.connection.DisconnectTimeout, "the timeout for disconnecting from the MQTT broker")
+	flag.StringVar(&cfg.connection.AcknowledgeTimeout, "mqtt-acknowledge-timeout", cfg.connection.AcknowledgeTimeout, "the timeout for the acknowledgement of published MQTT messages")
+	flag.StringVar(&cfg.connection.SubscribeTimeout, "mqtt-subscribe-timeout", cfg.connection.SubscribeTimeout, "the timeout for subscribing to MQTT topics")
+	flag.StringVar(&cfg.connection.UnsubscribeTimeout, "mqtt-unsubscribe-timeout", cfg.connection.UnsubscribeTimeout, "the timeout for unsubscribing from MQTT topics")
+
+	flag.StringVar(&updateagent.FileDirectory, "dir", "./fileagent", "the path to the directory where file agent will manage files")
+	flag.IntVar(&updateagent.KeepGenerations, "keep-generations", updateagent.KeepGenerations, "the number of previous generations of the files directory kept for fast revert")
+	flag.Int64Var(&updateagent.DirectoryQuota, "directory-quota", updateagent.DirectoryQuota, "the maximum size in bytes of the files directory including all its generations, 0 means no limit")
+	flag.DurationVar(&updateagent.ReconcileInterval, "reconcile-interval", updateagent.ReconcileInterval, "the interval of checking the managed files for drift from the last applied desired state, 0 disables the reconciliation")
+	flag.StringVar(&updateagent.ReconcilePolicy, "reconcile-policy", updateagent.ReconcilePolicy, "the action taken on drift of the managed files, either report or repair")
+	flag.DurationVar(&updateagent.WatchDebounce, "watch-debounce", updateagent.WatchDebounce, "the time to wait for further changes of the managed files before the current state is reported")
+	flag.IntVar(&updateagent.DownloadConcurrency, "download-concurrency", updateagent.DownloadConcurrency, "the maximum number of files downloaded in parallel")
+	flag.Int64Var(&updateagent.MaxFileSize, "download-max-size", updateagent.MaxFileSize, "the maximum size in bytes of a single downloaded file, 0 means no limit")
+	flag.Int64Var(&updateagent.BandwidthLimit, "download-bandwidth-limit", updateagent.BandwidthLimit, "the maximum download rate in bytes per second shared by all downloads, 0 means no limit")
+	flag.StringVar(&updateagent.DownloadWindows, "download-windows", updateagent.DownloadWindows, "the comma separated local time ranges when files can be downloaded, e.g. 01:00-05:00, downloads are allowed at any time if not set")
+	flag.IntVar(&updateagent.DownloadRetry.MaxAttempts, "download-retry-attempts", updateagent.DownloadRetry.MaxAttempts, "the maximum number of download attempts per file")
+	flag.DurationVar(&updateagent.DownloadRetry.InitialDelay, "download-retry-initial-delay", updateagent.DownloadRetry.InitialDelay, "the delay before the first download retry, doubled for each next retry")
+	flag.DurationVar(&updateagent.DownloadRetry.MaxDelay, "download-retry-max-delay", updateagent.DownloadRetry.MaxDelay, "the maximum delay between two download attempts, 0 means no limit")
+	flag.Float64Var(&updateagent.DownloadRetry.Jitter, "download-retry-jitter", updateagent.DownloadRetry.Jitter, "the fraction by which each download retry delay is randomly changed")
+	flag.DurationVar(&updateagent.DownloadRetry.MaxRetryAfter, "download-retry-max-retry-after", updateagent.DownloadRetry.MaxRetryAfter, "the longest delay requested by a server with the Retry-After header that is waited for, longer delays fail the download, 0 means no limit")
+	flag.DurationVar(&updateagent.HTTPTimeouts.Connect, "download-connect-timeout", updateagent.HTTPTimeouts.Connect, "the timeout for connecting to a download server, including the TLS handshake, 0 means no timeout")
+	flag.DurationVar(&updateagent.HTTPTimeouts.ResponseHeader, "download-header-timeout", updateagent.HTTPTimeouts.ResponseHeader, "the timeout for receiving the response headers from a download server, 0 means no timeout")
+	flag.DurationVar(&updateagent.HTTPTimeouts.IdleRead, "download-idle-timeout", updateagent.HTTPTimeouts.IdleRead, "the maximum time without receiving any content while downloading a file, 0 means no timeout")
+	flag.DurationVar(&updateagent.ProgressInterval, "download-progress-interval", updateagent.ProgressInterval, "the minimum time between two download progress feedback events")
+	flag.IntVar(&updateagent.ProgressStep, "download-progress-step", updateagent.ProgressStep, "the download progress in percent after which a feedback event is sent regardless of the progress interval")
+	flag.StringVar(&updateagent.CacheDirectory, "download-cache-dir", updateagent.CacheDirectory, "the directory of the content-addressed cache of downloaded files shared by all domains, the cache is disabled if not set")
+	flag.Int64Var(&updateagent.CacheSize, "download-cache-size", updateagent.CacheSize, "the maximum total size in bytes of the cached files, the least recently used files are removed once it is exceeded, 0 means no limit")
+	flag.Int64Var(&updateagent.MaxExtractedSize, "archive-max-size", updateagent.MaxExtractedSize, "the maximum total size in bytes of the content extracted from a single archive, 0 means no limit")
+	flag.IntVar(&updateagent.MaxExtractedEntries, "archive-max-entries", updateagent.MaxExtractedEntries, "the maximum number of entries extracted from a single archive, 0 means no limit")
+	flag.StringVar(&updateagent.S3.Endpoint, "s3-endpoint", updateagent.S3.Endpoint, "the URL of the S3-compatible object storage used for s3:// download URLs, defaults to the AWS endpoint of the region")
+	flag.StringVar(&updateagent.S3.Region, "s3-region", updateagent.S3.Region, "the region of the S3-compatible object storage")
+	flag.BoolVar(&updateagent.S3.PathStyle, "s3-path-style", updateagent.S3.PathStyle, "use path-style requests to the S3-compatible object storage")
+	flag.BoolVar(&updateagent.OCIPlainHTTP, "oci-plain-http", updateagent.OCIPlainHTTP, "use plain HTTP instead of HTTPS for oci:// download URLs")
+	flag.StringVar(&updateagent.FileSourceDirectories, "file-source-dirs", updateagent.FileSourceDirectories, "the comma separated local directories that files can be fetched from with file:// download URLs, file:// URLs are rejected if not set")
+	flag.StringVar(&updateagent.TrustStore, "trust-store", updateagent.TrustStore, "the directory with the PEM encoded public keys and root certificates trusted for signature verification")
+	flag.BoolVar(&updateagent.RequireSignatures, "require-signatures", updateagent.RequireSignatures, "reject downloaded files without a valid signature")
+	flag.StringVar(&updateagent.CredentialsDirectory, "credentials-dir", updateagent.CredentialsDirectory, "the directory with the secrets referenced by the HTTP options of the desired states, e.g. bearer tokens and client certificates")
+	flag.Var(&cfg.domains, "domain", "a domain managed by the agent in the form name=<domain>,dir=<directory>[,<option>=<value>...], can be repeated, values containing commas must be enclosed in double quotes. "+
+		"The options keep-generations, directory-quota, download-concurrency, trust-store, require-signatures, credentials-dir, reconcile-interval and reconcile-policy override the flags with the same names for the domain. "+
+		"If no domain is set, the domain set with -domain-name is managed in the directory set with -dir")
+}
+
+// loadConfig sets the configuration of the agent from the configuration file, the environment variables and the command line flags
+func loadConfig() (*config, error) {
+	cfg := &config{connection: mqtt.NewDefaultConfig()}
+	registerFlags(cfg)
+	flag.Parse()
+
+	if cfg.file == "" {
+		cfg.file = os.Getenv(environmentVariable("config"))
+	}
+	if cfg.file != "" {
+		if err := cfg.readFile(); err != nil {
+			return nil, fmt.Errorf("cannot read configuration file %s: %w", cfg.file, err)
+		}
+	}
+	if err := cfg.readEnvironment(); err != nil {
+		return nil, err
+	}
+
+	// the flags are parsed again, so that the ones set on the command line take precedence.
+	// Domains are replaced as a whole by the source with the highest precedence.
+	domains := cfg.domains
+	cfg.domains = nil
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if len(cfg.domains) == 0 {
+		cfg.domains = domains
+	}
+	return cfg, nil
+}
+
+func (cfg *config) readFile() error {
+	data, err := os.ReadFile(cfg.file)
+	if err != nil {
+		return err
+	}
+	file := &fileConfig{
+		LogLevel:        &cfg.logLevel,
+		Domain:          &cfg.domainName,
+		Directory:       &updateagent.FileDirectory,
+		KeepGenerations: &updateagent.KeepGenerations,
+		DirectoryQuota:  &updateagent.DirectoryQuota,
+		WatchDebounce:   (*duration)(&updateagent.WatchDebounce),
+		Reconcile: &reconcileConfig{
+			Interval: (*duration)(&updateagent.ReconcileInterval),
+			Policy:   &updateagent.ReconcilePolicy,
+		},
+		Signatures: &signaturesConfig{
+			TrustStore: &updateagent.TrustStore,
+			Required:   &updateagent.RequireSignatures,
+		},
+		CredentialsDir: &updateagent.CredentialsDirectory,
+		Connection:     cfg.connection,
+		Download: &downloadConfig{
+			Concurrency:    &updateagent.DownloadConcurrency,
+			MaxSize:        &updateagent.MaxFileSize,
+			BandwidthLimit: &updateagent.BandwidthLimit,
+			Windows:        &updateagent.DownloadWindows,
+			Retry: &retryConfig{
+				Attempts:      &updateagent.DownloadRetry.MaxAttempts,
+				InitialDelay:  (*duration)(&updateagent.DownloadRetry.InitialDelay),
+				MaxDelay:      (*duration)(&updateagent.DownloadRetry.MaxDelay),
+				Jitter:        &updateagent.DownloadRetry.Jitter,
+				MaxRetryAfter: (*duration)(&updateagent.DownloadRetry.MaxRetryAfter),
+			},
+			Timeouts: &timeoutsConfig{
+				Connect:        (*duration)(&updateagent.HTTPTimeouts.Connect),
+				ResponseHeader: (*duration)(&updateagent.HTTPTimeouts.ResponseHeader),
+				IdleRead:       (*duration)(&updateagent.HTTPTimeouts.IdleRead),
+			},
+			Progress: &progressConfig{
+				Interval: (*duration)(&updateagent.ProgressInterval),
+				Step:     &updateagent.ProgressStep,
+			},
+			Cache: &cacheConfig{
+				Directory: &updateagent.CacheDirectory,
+				Size:      &updateagent.CacheSize,
+			},
+			S3: &s3Config{
+				Endpoint:  &updateagent.S3.Endpoint,
+				Region:    &updateagent.S3.Region,
+				PathStyle: &updateagent.S3.PathStyle,
+			},
+			OCIPlainHTTP:   &updateagent.OCIPlainHTTP,
+			FileSourceDirs: &updateagent.FileSourceDirectories,
+		},
+		Archives: &archivesConfig{
+			MaxSize:    &updateagent.MaxExtractedSize,
+			MaxEntries: &updateagent.MaxExtractedEntries,
+		},
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// the numbers in the domain options are kept as written, e.g. 1073741824 instead of 1.073741824e+09
+	decoder.UseNumber()
+	if err = decoder.Decode(file); err != nil {
+		return err
+	}
+	for _, domain := range file.Domains {
+		options := map[string]string{}
+		for key, value := range domain {
+			options[key] = fmt.Sprint(value)
+		}
+		cfg.domains = append(cfg.domains, options)
+	}
+	return nil
+}
+
+// readEnvironment sets the flags from the matching environment variables.
+// Several domains can be set with the FILE_AGENT_DOMAIN environment variable, separated with semicolons.
+func (cfg *config) readEnvironment() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(environmentVariable(f.Name))
+		if !ok || err != nil || f.Name == "config" {
+			return
+		}
+		values := []string{value}
+		if f.Name == "domain" {
+			cfg.domains = nil
+			values = strings.Split(value, ";")
+		}
+		for _, value := range values {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid value of environment variable %s: %w", environmentVariable(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// domainConfigs creates the configurations of the managed domains
+func (cfg *config) domainConfigs() ([]*updateagent.DomainConfig, error) {
+	domains := []*updateagent.DomainConfig{}
+	for _, options := range cfg.domains {
+		domain, err := updateagent.NewDomainConfigFromOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		domains = append(domains, updateagent.NewDomainConfig(cfg.domainName, updateagent.FileDirectory))
+	}
+	return domains, updateagent.ValidateDomains(domains)
+}
+
+func environmentVariable(flagName string) string {
+	return environmentPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadFileDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		domains domainsFlag
+	}{
+		{
+			name:    "strings",
+			content: `{"domains": [{"name": "maps", "dir": "/var/lib/maps", "reconcile-policy": "repair"}]}`,
+			domains: domainsFlag{{"name": "maps", "dir": "/var/lib/maps", "reconcile-policy": "repair"}},
+		},
+		{
+			name:    "numbers and booleans",
+			content: `{"domains": [{"name": "maps", "dir": "/var/lib/maps", "directory-quota": 1073741824, "keep-generations": 2, "require-signatures": true}]}`,
+			domains: domainsFlag{{"name": "maps", "dir": "/var/lib/maps", "directory-quota": "1073741824", "keep-generations": "2", "require-signatures": "true"}},
+		},
+		{
+			name:    "several domains",
+			content: `{"domains": [{"name": "maps", "dir": "/var/lib/maps"}, {"name": "certs", "dir": "/etc/certs", "download-concurrency": 1}]}`,
+			domains: domainsFlag{{"name": "maps", "dir": "/var/lib/maps"}, {"name": "certs", "dir": "/etc/certs", "download-concurrency": "1"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(file, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config{file: file}
+			if err := cfg.readFile(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.domains, test.domains) {
+				t.Errorf("expected domains %v, got %v", test.domains, cfg.domains)
+			}
+		})
+	}
+}
+
+func TestDomainsFlagQuotedValue(t *testing.T) {
+	domains := domainsFlag{}
+	if err := domains.Set(`name=maps,dir="/var/lib/maps,v2"`); err != nil {
+		t.Fatal(err)
+	}
+	expected := domainsFlag{{"name": "maps", "dir": "/var/lib/maps,v2"}}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("expected domains %v, got %v", expected, domains)
+	}
+
+	// the printed value of the flag can be parsed again
+	parsed := domainsFlag{}
+	if err := parsed.Set(domains.String()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("expected domains %v, got %v", expected, parsed)
+	}
+}
@@ -1,11 +1,13 @@
 module github.com/eclipse-kanto/example-applications/custom-update-agent
 
-go 1.21
+go 1.22
 
 require (
 	github.com/eclipse-kanto/update-manager v0.1.0-M4.0.20240112143913-bbeef46051af
+	github.com/klauspost/compress v1.18.0
 	github.com/pkg/errors v0.9.1
 	github.com/rickar/props v1.0.0
+	github.com/ulikunitz/xz v0.5.12
 )
 
 require (
@@ -14,41 +14,63 @@ package main
 
 import (
 	"context"
-	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/eclipse-kanto/example-applications/custom-update-agent/updateagent"
 	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
 
 	"github.com/eclipse-kanto/update-manager/api"
-	"github.com/eclipse-kanto/update-manager/mqtt"
 )
 
 func main() {
-	logger := util.ConfigLogger(slog.LevelDebug, os.Stdout)
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logger := util.ConfigLogger(cfg.logLevel, os.Stdout)
 	slog.SetDefault(&logger)
 
-	flag.StringVar(&updateagent.FileDirectory, "dir", "./fileagent", "the path to the directory where file agent will manage files")
-	flag.Parse()
-
-	updateAgent, err := updateagent.Init(mqtt.NewDefaultConfig(), "files")
+	domains, err := cfg.domainConfigs()
 	if err != nil {
-		slog.Error("could not initialize an Update Agent service! got", "error", err)
+		slog.Error("invalid domain configuration", "error", err)
 		os.Exit(1)
 	}
-	if err := updateAgent.(api.UpdateAgent).Start(context.Background()); err != nil {
-		slog.Error("could not start Update Agent service! got", "error", err)
-		os.Exit(2)
-	}
-	slog.Info("successfully started Update Agent service")
 
-	var signalChan = make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+
+	updateAgents := []api.UpdateAgent{}
+	for _, domain := range domains {
+		updateAgent, err := updateagent.InitDomain(cfg.connection, domain)
+		if err != nil {
+			slog.Error("could not initialize an Update Agent service! got", "domain", domain.Name, "error", err)
+			os.Exit(1)
+		}
+		if err := updateAgent.(api.UpdateAgent).Start(ctx); err != nil {
+			slog.Error("could not start Update Agent service! got", "domain", domain.Name, "error", err)
+			os.Exit(2)
+		}
+		updateAgents = append(updateAgents, updateAgent.(api.UpdateAgent))
+		slog.Info("successfully started Update Agent service", "domain", domain.Name, "directory", domain.Directory)
+	}
 
-	sig := <-signalChan
-	slog.Info("Exiting!, received", "signal", sig)
-	updateAgent.(api.UpdateAgent).Stop()
+	<-ctx.Done()
+	slog.Info("Exiting!, received stop signal")
+	// the agents are stopped in parallel, each of them cancels its downloads in progress and reports their failure before disconnecting
+	var wg sync.WaitGroup
+	for _, updateAgent := range updateAgents {
+		wg.Add(1)
+		go func(updateAgent api.UpdateAgent) {
+			defer wg.Done()
+			if err := updateAgent.Stop(); err != nil {
+				slog.Error("could not stop Update Agent service! got", "error", err)
+			}
+		}(updateAgent)
+	}
+	wg.Wait()
 }
@@ -0,0 +1,357 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MaxExtractedSize is the maximum total size in bytes of the content extracted from a single archive, 0 means no limit
+var MaxExtractedSize int64 = 8 << 30
+
+// MaxExtractedEntries is the maximum number of entries extracted from a single archive, 0 means no limit
+var MaxExtractedEntries = 100000
+
+// maxSymlinkHops is the maximum number of symbolic links followed while resolving the target of an extracted symbolic link
+const maxSymlinkHops = 40
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicZip  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// extractArchive extracts the given .tar, .tar.gz, .tar.zst or .zip archive into the destination directory.
+// The content is extracted into a staging directory first, which then replaces the destination directory,
+// so the destination never contains a partially extracted archive.
+// Entries escaping the destination directory, either by their path or via symbolic links, are rejected.
+func extractArchive(archivePath string, destination string) error {
+	staging := filepath.Join(filepath.Dir(destination), "."+filepath.Base(destination)+".extracting")
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+
+	if err := extractArchiveTo(archivePath, staging); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("cannot extract archive [%s]: %w", filepath.Base(archivePath), err)
+	}
+	if err := os.RemoveAll(destination); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	return os.Rename(staging, destination)
+}
+
+func extractArchiveTo(archivePath string, destination string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, magicZip):
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(file, info.Size(), destination)
+	case bytes.HasPrefix(magic, magicGzip):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		return extractTar(gzipReader, destination)
+	case bytes.HasPrefix(magic, magicZstd):
+		zstdReader, err := newZstdReader(reader)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+		return extractTar(zstdReader, destination)
+	}
+	return extractTar(reader, destination)
+}
+
+func extractTar(reader io.Reader, destination string) error {
+	tarReader := tar.NewReader(reader)
+	limits := &extractionLimits{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = limits.entry(); err != nil {
+			return err
+		}
+		target, err := securePath(destination, header.Name)
+		if err != nil {
+			return err
+		}
+		mode := header.FileInfo().Mode().Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg:
+			err = writeExtractedFile(target, limits.reader(tarReader), mode)
+		case tar.TypeSymlink:
+			err = createSymlink(destination, target, header.Linkname)
+		case tar.TypeLink:
+			err = createHardLink(destination, target, header.Linkname)
+		default:
+			slog.Debug(fmt.Sprintf("skipping unsupported archive entry [%s] of type %c", header.Name, header.Typeflag))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(reader io.ReaderAt, size int64, destination string) error {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		return err
+	}
+	limits := &extractionLimits{}
+	for _, entry := range zipReader.File {
+		if err = limits.entry(); err != nil {
+			return err
+		}
+		target, err := securePath(destination, entry.Name)
+		if err != nil {
+			return err
+		}
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, mode.Perm()|0700)
+		case mode&fs.ModeSymlink != 0:
+			err = extractZipSymlink(destination, target, entry)
+		case mode.IsRegular():
+			err = extractZipFile(target, entry, limits)
+		default:
+			slog.Debug(fmt.Sprintf("skipping unsupported archive entry [%s]", entry.Name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(target string, entry *zip.File, limits *extractionLimits) error {
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return writeExtractedFile(target, limits.reader(content), entry.Mode().Perm())
+}
+
+func extractZipSymlink(destination string, target string, entry *zip.File) error {
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	link, err := io.ReadAll(io.LimitReader(content, 4096))
+	if err != nil {
+		return err
+	}
+	return createSymlink(destination, target, string(link))
+}
+
+func writeExtractedFile(target string, content io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// createSymlink creates a symbolic link, provided that its target stays inside the destination directory
+func createSymlink(destination string, target string, link string) error {
+	if filepath.IsAbs(link) {
+		return fmt.Errorf("symbolic link [%s] with absolute target [%s] is not allowed", target, link)
+	}
+	if err := checkSymlinkTarget(destination, target, link); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+// checkSymlinkTarget resolves the target of a symbolic link placed at the given path the way the file system would,
+// following the symbolic links already extracted into the destination directory, and fails if it leads outside of it.
+// Targets stepping out of a directory that does not exist yet are rejected as well,
+// as the directory could still be extracted as a symbolic link pointing elsewhere.
+func checkSymlinkTarget(destination string, target string, link string) error {
+	escaping := fmt.Errorf("symbolic link [%s] pointing outside of the extraction directory is not allowed", target)
+	current := filepath.Dir(target)
+	pending := strings.Split(filepath.ToSlash(link), "/")
+	for hops := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			if !isInside(destination, current) {
+				return escaping
+			}
+			continue
+		}
+		next := filepath.Join(current, name)
+		info, err := os.Lstat(next)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if slices.Contains(pending, "..") {
+				return escaping
+			}
+			current = next
+		case err != nil:
+			return err
+		case info.Mode()&fs.ModeSymlink != 0:
+			if hops++; hops > maxSymlinkHops {
+				return fmt.Errorf("too many levels of symbolic links resolving symbolic link [%s]", target)
+			}
+			linked, err := os.Readlink(next)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(linked) {
+				return escaping
+			}
+			// the target of the followed link is resolved relative to the directory containing it, which is the current one
+			pending = append(strings.Split(filepath.ToSlash(linked), "/"), pending...)
+		default:
+			current = next
+		}
+	}
+	return nil
+}
+
+// createHardLink creates a hard link to an already extracted file.
+// Links to symbolic links are rejected, as the relative target of the linked symbolic link would be resolved from another directory.
+func createHardLink(destination string, target string, name string) error {
+	source, err := securePath(destination, name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hard link [%s] to [%s], which is not a regular file, is not allowed", target, name)
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// securePath returns the path of an archive entry inside the destination directory.
+// Entries with absolute paths, entries escaping the destination directory and entries placed under symbolic links are rejected.
+func securePath(destination string, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry [%s] pointing outside of the extraction directory is not allowed", name)
+	}
+	target := filepath.Join(destination, cleaned)
+	// none of the parent directories may be a symbolic link, otherwise the entry could be written outside of the destination
+	for parent := filepath.Dir(target); isInside(destination, parent) && parent != destination; parent = filepath.Dir(parent) {
+		info, err := os.Lstat(parent)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry [%s] placed under a symbolic link is not allowed", name)
+		}
+	}
+	return target, nil
+}
+
+func isInside(directory string, path string) bool {
+	relative, err := filepath.Rel(directory, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// extractionLimits counts the entries and the content extracted from an archive,
+// so that an archive expanding to an excessive number or size of files cannot fill the disk
+type extractionLimits struct {
+	entries int
+	size    int64
+}
+
+func (l *extractionLimits) entry() error {
+	l.entries++
+	if MaxExtractedEntries > 0 && l.entries > MaxExtractedEntries {
+		return fmt.Errorf("archive exceeds the maximum number of %d entries", MaxExtractedEntries)
+	}
+	return nil
+}
+
+// reader returns a reader of the content of an extracted file, which fails once the total extracted size exceeds the limit
+func (l *extractionLimits) reader(content io.Reader) io.Reader {
+	return &extractedContentReader{reader: content, limits: l}
+}
+
+type extractedContentReader struct {
+	reader io.Reader
+	limits *extractionLimits
+}
+
+func (r *extractedContentReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.limits.size += int64(n)
+	if MaxExtractedSize > 0 && r.limits.size > MaxExtractedSize {
+		return n, fmt.Errorf("archive exceeds the maximum extracted size of %d bytes", MaxExtractedSize)
+	}
+	return n, err
+}
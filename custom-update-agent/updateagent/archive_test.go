@@ -0,0 +1,267 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	link     string
+}
+
+func fileEntry(name string, content string) archiveEntry {
+	return archiveEntry{name: name, typeflag: tar.TypeReg, content: content}
+}
+
+func dirEntry(name string) archiveEntry {
+	return archiveEntry{name: name, typeflag: tar.TypeDir}
+}
+
+func symlinkEntry(name string, link string) archiveEntry {
+	return archiveEntry{name: name, typeflag: tar.TypeSymlink, link: link}
+}
+
+func hardlinkEntry(name string, link string) archiveEntry {
+	return archiveEntry{name: name, typeflag: tar.TypeLink, link: link}
+}
+
+func writeTar(t *testing.T, path string, entries ...archiveEntry) {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.link, Mode: 0644, Size: int64(len(entry.content))}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need additional privileges on Windows")
+	}
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		files   map[string]string
+		err     string
+	}{
+		{
+			name:    "files and directories",
+			entries: []archiveEntry{dirEntry("bin"), fileEntry("bin/app", "app"), fileEntry("etc/app.conf", "conf")},
+			files:   map[string]string{"bin/app": "app", "etc/app.conf": "conf"},
+		},
+		{
+			name:    "symbolic and hard links inside",
+			entries: []archiveEntry{fileEntry("lib/libapp.so.1", "lib"), symlinkEntry("lib/libapp.so", "libapp.so.1"), hardlinkEntry("lib/copy.so", "lib/libapp.so.1")},
+			files:   map[string]string{"lib/libapp.so": "lib", "lib/copy.so": "lib"},
+		},
+		{
+			name:    "symbolic link to the destination itself",
+			entries: []archiveEntry{dirEntry("sub"), symlinkEntry("sub/root", ".."), fileEntry("a", "a")},
+			files:   map[string]string{"sub/root/a": "a"},
+		},
+		{
+			name:    "relative path escaping",
+			entries: []archiveEntry{fileEntry("../escaped", "x")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "nested path escaping",
+			entries: []archiveEntry{fileEntry("a/../../escaped", "x")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{fileEntry("/tmp/escaped", "x")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "absolute symbolic link",
+			entries: []archiveEntry{symlinkEntry("etc", "/etc")},
+			err:     "absolute target",
+		},
+		{
+			name:    "symbolic link escaping",
+			entries: []archiveEntry{symlinkEntry("up", "../..")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "chain of symbolic links escaping",
+			entries: []archiveEntry{dirEntry("sub"), symlinkEntry("sub/b", ".."), symlinkEntry("e", "sub/b/../..")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "symbolic link through a directory extracted later",
+			entries: []archiveEntry{symlinkEntry("e", "sub/b/../.."), dirEntry("sub"), symlinkEntry("sub/b", "..")},
+			err:     "outside of the extraction directory",
+		},
+		{
+			name:    "entry under a symbolic link",
+			entries: []archiveEntry{dirEntry("sub"), symlinkEntry("sub/root", ".."), fileEntry("sub/root/a", "a")},
+			err:     "under a symbolic link",
+		},
+		{
+			name:    "hard link to a symbolic link",
+			entries: []archiveEntry{dirEntry("a/b"), symlinkEntry("a/b/l", "../.."), hardlinkEntry("l", "a/b/l")},
+			err:     "not a regular file",
+		},
+		{
+			name:    "hard link escaping",
+			entries: []archiveEntry{hardlinkEntry("passwd", "../../etc/passwd")},
+			err:     "outside of the extraction directory",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			archive := filepath.Join(root, "archive.tar")
+			writeTar(t, archive, test.entries...)
+			destination := filepath.Join(root, "extracted", "dest")
+
+			err := extractArchive(archive, destination)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				if _, err := os.Stat(destination); !os.IsNotExist(err) {
+					t.Errorf("expected no destination directory after a failed extraction, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range test.files {
+				data, err := os.ReadFile(filepath.Join(destination, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != content {
+					t.Errorf("expected content %q of %s, got %q", content, name, data)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	maxSize, maxEntries := MaxExtractedSize, MaxExtractedEntries
+	defer func() { MaxExtractedSize, MaxExtractedEntries = maxSize, maxEntries }()
+
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxEntries int
+		err        string
+	}{
+		{name: "within the limits", maxSize: 10, maxEntries: 3},
+		{name: "no limits"},
+		{name: "too many entries", maxSize: 10, maxEntries: 2, err: "maximum number of 2 entries"},
+		{name: "too large", maxSize: 9, maxEntries: 3, err: "maximum extracted size of 9 bytes"},
+	}
+	for _, test := range tests {
+		for _, format := range []string{"tar", "zip"} {
+			t.Run(test.name+" "+format, func(t *testing.T) {
+				MaxExtractedSize, MaxExtractedEntries = test.maxSize, test.maxEntries
+				root := t.TempDir()
+				archive := filepath.Join(root, "archive."+format)
+				files := []archiveEntry{dirEntry("d"), fileEntry("d/a", "12345"), fileEntry("b", "67890")}
+				if format == "tar" {
+					writeTar(t, archive, files...)
+				} else {
+					writeZip(t, archive, files...)
+				}
+
+				err := extractArchive(archive, filepath.Join(root, "dest"))
+				if test.err == "" && err != nil {
+					t.Fatal(err)
+				}
+				if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			})
+		}
+	}
+}
+
+func writeZip(t *testing.T, path string, entries ...archiveEntry) {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name}
+		switch entry.typeflag {
+		case tar.TypeDir:
+			header.Name += "/"
+			header.SetMode(fs.ModeDir | 0755)
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0777)
+			entry.content = entry.link
+		default:
+			header.SetMode(0644)
+		}
+		content, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = content.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZipSymlinkEscaping(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need additional privileges on Windows")
+	}
+	root := t.TempDir()
+	archive := filepath.Join(root, "archive.zip")
+	writeZip(t, archive, dirEntry("sub"), symlinkEntry("sub/b", ".."), symlinkEntry("e", "sub/b/../.."))
+
+	err := extractArchive(archive, filepath.Join(root, "dest"))
+	if err == nil || !strings.Contains(err.Error(), "outside of the extraction directory") {
+		t.Fatalf("expected escaping symbolic link to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+type checksum struct {
+	algorithm string
+	expected  string
+	hash      hash.Hash
+}
+
+// checksumVerifier computes the digests of a file while its content is streamed and compares them with the expected ones
+type checksumVerifier struct {
+	fileName  string
+	checksums []*checksum
+}
+
+func newChecksumVerifier(desired *util.File) *checksumVerifier {
+	verifier := &checksumVerifier{fileName: desired.Name}
+	if desired.SHA256 != "" {
+		verifier.checksums = append(verifier.checksums, &checksum{algorithm: "sha256", expected: desired.SHA256, hash: sha256.New()})
+	}
+	if desired.SHA512 != "" {
+		verifier.checksums = append(verifier.checksums, &checksum{algorithm: "sha512", expected: desired.SHA512, hash: sha512.New()})
+	}
+	return verifier
+}
+
+// expect adds a digest in the form <algorithm>:<hex> announced by the artifact source, unless a checksum for that algorithm is already configured
+func (v *checksumVerifier) expect(digest string) error {
+	if digest == "" {
+		return nil
+	}
+	algorithm, expected, _ := strings.Cut(strings.ToLower(digest), ":")
+	for _, c := range v.checksums {
+		if c.algorithm == algorithm {
+			return nil
+		}
+	}
+	switch algorithm {
+	case "sha256":
+		v.checksums = append(v.checksums, &checksum{algorithm: algorithm, expected: expected, hash: sha256.New()})
+	case "sha512":
+		v.checksums = append(v.checksums, &checksum{algorithm: algorithm, expected: expected, hash: sha512.New()})
+	default:
+		return fmt.Errorf("unsupported digest algorithm [%s] for file [%s]", algorithm, v.fileName)
+	}
+	return nil
+}
+
+// writer returns a writer feeding all configured digests, io.Discard if no checksums are configured
+func (v *checksumVerifier) writer() io.Writer {
+	if len(v.checksums) == 0 {
+		return io.Discard
+	}
+	writers := make([]io.Writer, len(v.checksums))
+	for i, c := range v.checksums {
+		writers[i] = c.hash
+	}
+	return io.MultiWriter(writers...)
+}
+
+// verify compares the computed digests with the expected ones
+func (v *checksumVerifier) verify() error {
+	for _, c := range v.checksums {
+		actual := hex.EncodeToString(c.hash.Sum(nil))
+		if actual != c.expected {
+			return fmt.Errorf("%s checksum mismatch for file [%s], expected %s, but got %s", c.algorithm, v.fileName, c.expected, actual)
+		}
+	}
+	return nil
+}
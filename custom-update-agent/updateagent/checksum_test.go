@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestChecksumVerifier(t *testing.T) {
+	sha256Sum := sha256.Sum256([]byte(testContent))
+	sha512Sum := sha512.Sum512([]byte(testContent))
+	contentSHA256 := hex.EncodeToString(sha256Sum[:])
+	contentSHA512 := hex.EncodeToString(sha512Sum[:])
+	otherSHA256 := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name      string
+		desired   *util.File
+		announced string
+		expectErr string
+		verifyErr string
+	}{
+		{name: "no checksums", desired: &util.File{}},
+		{name: "sha256", desired: &util.File{SHA256: contentSHA256}},
+		{name: "sha512", desired: &util.File{SHA512: contentSHA512}},
+		{name: "both", desired: &util.File{SHA256: contentSHA256, SHA512: contentSHA512}},
+		{name: "sha256 mismatch", desired: &util.File{SHA256: otherSHA256}, verifyErr: "sha256 checksum mismatch"},
+		{name: "sha512 mismatch", desired: &util.File{SHA256: contentSHA256, SHA512: strings.Repeat("0", 128)}, verifyErr: "sha512 checksum mismatch"},
+		{name: "announced digest", desired: &util.File{}, announced: "SHA256:" + strings.ToUpper(contentSHA256)},
+		{name: "announced digest mismatch", desired: &util.File{}, announced: "sha256:" + otherSHA256, verifyErr: "sha256 checksum mismatch"},
+		{name: "configured checksum takes precedence", desired: &util.File{SHA256: contentSHA256}, announced: "sha256:" + otherSHA256},
+		{name: "unsupported announced digest", desired: &util.File{}, announced: "md5:abc", expectErr: "unsupported digest algorithm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.desired.Name = "app.bin"
+			verifier := newChecksumVerifier(test.desired)
+			err := verifier.expect(test.announced)
+			if test.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectErr) {
+					t.Fatalf("expected error containing %q, got %v", test.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the content is streamed in small chunks
+			if _, err = io.CopyBuffer(verifier.writer(), strings.NewReader(testContent), make([]byte, 5)); err != nil {
+				t.Fatal(err)
+			}
+			err = verifier.verify()
+			if test.verifyErr == "" && err != nil {
+				t.Errorf("expected the checksums to match, got %v", err)
+			} else if test.verifyErr != "" && (err == nil || !strings.Contains(err.Error(), test.verifyErr)) {
+				t.Errorf("expected error containing %q, got %v", test.verifyErr, err)
+			}
+		})
+	}
+}
+
+func TestChecksumMismatchFailsDownload(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	sum := sha256.Sum256([]byte("other"))
+
+	desiredState := newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt", "b.txt": server.URL + "/b.txt"})
+	updMgr.Apply(context.Background(), "activity", withComponentConfig(desiredState, "b.txt", &types.KeyValuePair{Key: "sha256", Value: hex.EncodeToString(sum[:])}))
+	updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+	if !hasStatus(callback.statuses(), types.BaselineStatusDownloadFailure) {
+		t.Fatalf("expected status %s, got %v", types.BaselineStatusDownloadFailure, callback.statuses())
+	}
+	for _, action := range callback.last().actions {
+		if action.Component.ID == "files:b.txt" && (action.Status != types.ActionStatusDownloadFailure || !strings.Contains(action.Message, "checksum mismatch")) {
+			t.Errorf("expected the checksum mismatch to be reported for b.txt, got %s %q", action.Status, action.Message)
+		}
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(updMgr.directory.currentDirectory(), name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be installed, got %v", name, err)
+		}
+	}
+}
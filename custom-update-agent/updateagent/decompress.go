@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var magicXz = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+// detectCompression returns the compression of the downloaded content of the desired file.
+// The compression configured for the file takes precedence over the Content-Encoding announced by the source.
+// The magic bytes at the start of the content are checked only for files with auto compression,
+// so that files which are published compressed, e.g. .gz downloads, are kept as they are by default.
+// An unsupported Content-Encoding fails the download only for files with auto compression.
+// Archives are not decompressed while downloading, as they are decompressed while extracted.
+func detectCompression(desired *util.File, encoding string, content *bufio.Reader) (string, error) {
+	if desired.Compression != "" && desired.Compression != util.CompressionAuto {
+		return desired.Compression, nil
+	}
+	if desired.IsArchive() {
+		return util.CompressionNone, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		return util.CompressionGzip, nil
+	case "zstd":
+		return util.CompressionZstd, nil
+	case "xz":
+		return util.CompressionXz, nil
+	default:
+		if desired.Compression == util.CompressionAuto {
+			return "", fmt.Errorf("unsupported content encoding [%s] of file [%s]", encoding, desired.Name)
+		}
+		// the content is stored as received, the checksum detects if it was expected otherwise
+		return util.CompressionNone, nil
+	}
+	if desired.Compression != util.CompressionAuto {
+		return util.CompressionNone, nil
+	}
+	magic, _ := content.Peek(len(magicXz))
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		return util.CompressionGzip, nil
+	case bytes.HasPrefix(magic, magicZstd):
+		return util.CompressionZstd, nil
+	case bytes.HasPrefix(magic, magicXz):
+		return util.CompressionXz, nil
+	}
+	return util.CompressionNone, nil
+}
+
+// newDecompressor returns a reader of the decompressed content of the given reader
+func newDecompressor(reader io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case util.CompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return gzipReader, nil
+	case util.CompressionZstd:
+		return newZstdReader(reader)
+	case util.CompressionXz:
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	}
+	return io.NopCloser(reader), nil
+}
+
+// newZstdReader returns a reader of the decompressed content of the given zstd stream.
+// The content is decoded synchronously while it is read, so that no goroutines are left running when the reader is closed.
+func newZstdReader(reader io.Reader, options ...zstd.DOption) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(reader, append([]zstd.DOption{zstd.WithDecoderConcurrency(1)}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
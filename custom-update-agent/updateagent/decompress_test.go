@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const testContent = "the quick brown fox jumps over the lazy dog"
+
+func compress(t *testing.T, compression string, content string) []byte {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case util.CompressionGzip:
+		writer = gzip.NewWriter(buffer)
+	case util.CompressionZstd:
+		writer, err = zstd.NewWriter(buffer)
+	case util.CompressionXz:
+		writer, err = xz.NewWriter(buffer)
+	default:
+		return []byte(content)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        *util.File
+		encoding    string
+		content     string
+		compression string
+		err         bool
+	}{
+		{name: "plain", file: &util.File{}, content: util.CompressionNone, compression: util.CompressionNone},
+		{name: "gzip content without encoding", file: &util.File{}, content: util.CompressionGzip, compression: util.CompressionNone},
+		{name: "gzip encoding", file: &util.File{}, encoding: "gzip", content: util.CompressionGzip, compression: util.CompressionGzip},
+		{name: "x-gzip encoding", file: &util.File{}, encoding: "X-Gzip", content: util.CompressionGzip, compression: util.CompressionGzip},
+		{name: "zstd encoding", file: &util.File{}, encoding: "zstd", content: util.CompressionZstd, compression: util.CompressionZstd},
+		{name: "xz encoding", file: &util.File{}, encoding: "xz", content: util.CompressionXz, compression: util.CompressionXz},
+		{name: "identity encoding", file: &util.File{}, encoding: "identity", content: util.CompressionNone, compression: util.CompressionNone},
+		{name: "unsupported encoding", file: &util.File{}, encoding: "br", content: util.CompressionNone, compression: util.CompressionNone},
+		{name: "auto unsupported encoding", file: &util.File{Compression: util.CompressionAuto}, encoding: "deflate", content: util.CompressionNone, err: true},
+		{name: "configured", file: &util.File{Compression: util.CompressionZstd}, encoding: "gzip", content: util.CompressionZstd, compression: util.CompressionZstd},
+		{name: "configured none", file: &util.File{Compression: util.CompressionNone}, encoding: "gzip", content: util.CompressionGzip, compression: util.CompressionNone},
+		{name: "auto gzip", file: &util.File{Compression: util.CompressionAuto}, content: util.CompressionGzip, compression: util.CompressionGzip},
+		{name: "auto zstd", file: &util.File{Compression: util.CompressionAuto}, content: util.CompressionZstd, compression: util.CompressionZstd},
+		{name: "auto xz", file: &util.File{Compression: util.CompressionAuto}, content: util.CompressionXz, compression: util.CompressionXz},
+		{name: "auto plain", file: &util.File{Compression: util.CompressionAuto}, content: util.CompressionNone, compression: util.CompressionNone},
+		{name: "auto encoding", file: &util.File{Compression: util.CompressionAuto}, encoding: "gzip", content: util.CompressionGzip, compression: util.CompressionGzip},
+		{name: "archive", file: &util.File{Type: util.FileTypeArchive}, encoding: "gzip", content: util.CompressionGzip, compression: util.CompressionNone},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := bufio.NewReader(bytes.NewReader(compress(t, test.content, testContent)))
+			compression, err := detectCompression(test.file, test.encoding, content)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got compression %s", compression)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compression != test.compression {
+				t.Errorf("expected compression %s, got %s", test.compression, compression)
+			}
+		})
+	}
+}
+
+func TestNewDecompressor(t *testing.T) {
+	for _, compression := range []string{util.CompressionNone, util.CompressionGzip, util.CompressionZstd, util.CompressionXz} {
+		t.Run(compression, func(t *testing.T) {
+			reader, err := newDecompressor(bytes.NewReader(compress(t, compression, testContent)), compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testContent {
+				t.Errorf("expected content %q, got %q", testContent, content)
+			}
+		})
+	}
+}
+
+func TestNewDecompressorCorruptContent(t *testing.T) {
+	for _, compression := range []string{util.CompressionGzip, util.CompressionZstd, util.CompressionXz} {
+		t.Run(compression, func(t *testing.T) {
+			compressed := compress(t, compression, strings.Repeat(testContent, 100))
+			// the header is kept, so that the corruption is only detected while reading
+			corrupt := append(compressed[:len(compressed)/2:len(compressed)/2], bytes.Repeat([]byte{0xff}, 16)...)
+			reader, err := newDecompressor(bytes.NewReader(corrupt), compression)
+			if err == nil {
+				defer reader.Close()
+				_, err = io.ReadAll(reader)
+			}
+			if err == nil {
+				t.Error("expected the corrupt content to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+// installedPath returns the path of the given file in the given generation directory, for archives the path of their extraction directory
+func installedPath(directory string, file *util.File) string {
+	if file.IsArchive() {
+		return filepath.Join(directory, file.ExtractTo)
+	}
+	return filepath.Join(directory, file.Name)
+}
+
+// contentDigest computes the digest and the size of the installed content of the given file in the given generation directory.
+// The digest of an archive covers the whole extracted tree, i.e. the paths, permissions and contents of its files and the targets of its symbolic links.
+func contentDigest(directory string, file *util.File) (string, int64, error) {
+	path := installedPath(directory, file)
+	if !file.IsArchive() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", 0, err
+		}
+		digest, err := fileSHA256(path)
+		if err != nil {
+			return "", 0, err
+		}
+		return "sha256:" + hex.EncodeToString(digest), info.Size(), nil
+	}
+
+	tree := sha256.New()
+	var size int64
+	err := filepath.WalkDir(path, func(entryPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(path, entryPath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			fmt.Fprintf(tree, "d %s %o\n", relativePath, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(entryPath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tree, "l %s %s\n", relativePath, link)
+		case entry.Type().IsRegular():
+			digest, err := fileSHA256(entryPath)
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			fmt.Fprintf(tree, "f %s %o %x\n", relativePath, info.Mode().Perm(), digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(tree.Sum(nil)), size, nil
+}
+
+// detectDrift marks the given current file as missing, if its installed content no longer exists in the given generation directory,
+// or as modified, if the content no longer matches its recorded digest
+func detectDrift(directory string, current *util.File) {
+	if current.Digest == "" {
+		// recorded by earlier versions of the agent, only the existence of the content can be verified
+		if _, err := os.Lstat(installedPath(directory, current)); errors.Is(err, fs.ErrNotExist) {
+			current.Drift = util.DriftMissing
+		}
+		return
+	}
+	digest, _, err := contentDigest(directory, current)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		current.Drift = util.DriftMissing
+	case err != nil || digest != current.Digest:
+		current.Drift = util.DriftModified
+	}
+}
+
+// unexpectedFiles returns the entries of the given generation directory, which do not belong to any file recorded in the given state
+func unexpectedFiles(directory string, state *fileState) ([]*util.File, error) {
+	known := map[string]bool{stateFileName: true, stateFileName + ".tmp": true, legacyStateFileName: true}
+	for _, file := range state.Files {
+		known[file.Name] = true
+		if file.IsArchive() {
+			known[strings.Split(filepath.ToSlash(filepath.Clean(file.ExtractTo)), "/")[0]] = true
+		}
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	unexpected := []*util.File{}
+	for _, entry := range entries {
+		if !known[entry.Name()] {
+			unexpected = append(unexpected, &util.File{Name: entry.Name(), DownloadURL: "unknown", Drift: util.DriftUnexpected})
+		}
+	}
+	return unexpected, nil
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+func TestDetectDrift(t *testing.T) {
+	plainFile := &util.File{Name: "a.bin"}
+	archive := &util.File{Name: "app.tar", Type: util.FileTypeArchive, ExtractTo: "app/lib"}
+	tests := []struct {
+		name   string
+		file   *util.File
+		modify func(directory string) error
+		legacy bool
+		drift  string
+	}{
+		{name: "unchanged file", file: plainFile},
+		{name: "modified file", file: plainFile, modify: writeTestFile("a.bin", "other"), drift: util.DriftModified},
+		{name: "removed file", file: plainFile, modify: removeTestFile("a.bin"), drift: util.DriftMissing},
+		{name: "unchanged archive", file: archive},
+		{name: "modified archive file", file: archive, modify: writeTestFile("app/lib/bin/run", "other"), drift: util.DriftModified},
+		{name: "added archive file", file: archive, modify: writeTestFile("app/lib/extra", ""), drift: util.DriftModified},
+		{name: "removed archive file", file: archive, modify: removeTestFile("app/lib/bin/run"), drift: util.DriftModified},
+		{
+			name:   "changed archive file permissions",
+			file:   archive,
+			modify: func(directory string) error { return os.Chmod(filepath.Join(directory, "app/lib/bin/run"), 0600) },
+			drift:  util.DriftModified,
+		},
+		{name: "removed archive", file: archive, modify: removeTestFile("app"), drift: util.DriftMissing},
+		{name: "modified file without digest", file: plainFile, modify: writeTestFile("a.bin", "other"), legacy: true},
+		{name: "removed file without digest", file: plainFile, modify: removeTestFile("a.bin"), legacy: true, drift: util.DriftMissing},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			for _, create := range []func(string) error{writeTestFile("a.bin", testContent), writeTestFile("app/lib/bin/run", testContent)} {
+				if err := create(directory); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file := *test.file
+			if !test.legacy {
+				digest, _, err := contentDigest(directory, &file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				file.Digest = digest
+			}
+			if test.modify != nil {
+				if err := test.modify(directory); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			detectDrift(directory, &file)
+			if file.Drift != test.drift {
+				t.Errorf("expected drift [%s], got [%s]", test.drift, file.Drift)
+			}
+		})
+	}
+}
+
+func TestContentDigest(t *testing.T) {
+	directory := t.TempDir()
+	if err := writeTestFile("app/lib/run", testContent)(directory); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run", filepath.Join(directory, "app/lib/link")); err != nil {
+		t.Fatal(err)
+	}
+	archive := &util.File{Name: "app.tar", Type: util.FileTypeArchive, ExtractTo: "app/lib"}
+	digest, size, err := contentDigest(directory, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(testContent)) {
+		t.Errorf("expected size %d, got %d", len(testContent), size)
+	}
+
+	// the target of a symbolic link is part of the digest
+	if err = os.Remove(filepath.Join(directory, "app/lib/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("other", filepath.Join(directory, "app/lib/link")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _, err := contentDigest(directory, archive); err != nil || changed == digest {
+		t.Errorf("expected another digest for the changed link target, got %s (%v)", changed, err)
+	}
+}
+
+func TestDigestRecordedForLegacyFiles(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	installTestFiles(t, updMgr, callback, "first", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}))
+
+	// the digest is missing as if the state was migrated from a state.props file
+	directory := updMgr.directory.currentDirectory()
+	state, err := readState(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := state.find("a.txt").Digest
+	state.find("a.txt").Digest = ""
+	if err = writeState(directory, state); err != nil {
+		t.Fatal(err)
+	}
+
+	installTestFiles(t, updMgr, callback, "second", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt", "b.txt": server.URL + "/b.txt"}))
+	if state, err = readState(updMgr.directory.currentDirectory()); err != nil {
+		t.Fatal(err)
+	}
+	if recorded := state.find("a.txt"); recorded.Digest != expected || recorded.ActivityID != "first" {
+		t.Errorf("expected the kept record of activity first with digest %s, got %+v", expected, recorded)
+	}
+}
+
+func TestUnexpectedFiles(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"a.bin", "app/lib/run", "other.bin", "extra/file", stateFileName} {
+		if err := writeTestFile(name, "")(directory); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := &fileState{Files: []*installedFile{
+		{File: util.File{Name: "a.bin"}},
+		{File: util.File{Name: "app.tar", Type: util.FileTypeArchive, ExtractTo: "app/lib"}},
+		{File: util.File{Name: "missing.bin"}},
+	}}
+	unexpected, err := unexpectedFiles(directory, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, file := range unexpected {
+		if file.Drift != util.DriftUnexpected {
+			t.Errorf("expected drift [%s] of file [%s], got [%s]", util.DriftUnexpected, file.Name, file.Drift)
+		}
+		names[file.Name] = true
+	}
+	if len(names) != 2 || !names["other.bin"] || !names["extra"] {
+		t.Errorf("expected unexpected files [other.bin] and [extra], got %v", names)
+	}
+}
+
+func writeTestFile(name string, content string) func(directory string) error {
+	return func(directory string) error {
+		path := filepath.Join(directory, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(content), 0755)
+	}
+}
+
+func removeTestFile(name string) func(directory string) error {
+	return func(directory string) error {
+		return os.RemoveAll(filepath.Join(directory, name))
+	}
+}
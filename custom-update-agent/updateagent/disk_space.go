@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// DirectoryQuota is the maximum size in bytes of a managed directory including all its generations, 0 means no limit.
+// It can be overridden per domain.
+var DirectoryQuota int64
+
+// sizeRequestTimeout limits the time for determining the sizes of the files to be downloaded during identification
+const sizeRequestTimeout = 30 * time.Second
+
+// checkDiskSpace estimates the space needed by the given actions and verifies that it is available on the file system of the managed directory,
+// and that the managed directory stays within its quota.
+// The downloaded files are stored in the downloads directory, while the staged generation holds a copy of the current generation and the new files.
+// The size of the files is taken from their declared size or requested from their source, files of unknown size are not taken into account.
+// The extracted content of archives is estimated with the size of the archives.
+func (o *operation) checkDiskSpace(ctx context.Context, actions []*fileAction) error {
+	ctx, cancel := context.WithTimeout(ctx, sizeRequestTimeout)
+	defer cancel()
+
+	var download int64
+	for _, action := range actions {
+		if !action.needsDownload() {
+			continue
+		}
+		size, err := o.client.contentSize(ctx, action.desired)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("cannot determine the size of file [%s], it is not considered in the disk space check", action.desired.Name), "error", err)
+			continue
+		}
+		if size > 0 {
+			download += size
+			action.size = size
+		}
+	}
+	current, err := directorySize(o.directory.generationDirectory(o.previousGeneration))
+	if err != nil {
+		return err
+	}
+	staged := current + download
+
+	if quota := o.updateManager.config.DirectoryQuota; quota > 0 {
+		// partial downloads of the operation are already covered by the size of the files to be downloaded
+		used, err := directorySize(o.directory.generationsDirectory())
+		if err != nil {
+			return err
+		}
+		if used+download+staged > quota {
+			return fmt.Errorf("the update needs %s in files directory [%s], which exceeds its quota of %s with %s already used",
+				formatBytes(download+staged), o.directory, formatBytes(quota), formatBytes(used))
+		}
+	}
+
+	available, err := fileSystemSpace(string(o.directory))
+	if err != nil {
+		slog.Debug("cannot determine available disk space, skipping disk space check", "error", err)
+		return nil
+	}
+	return checkAvailable(string(o.directory), download+staged, available)
+}
+
+func checkAvailable(path string, needed int64, available uint64) error {
+	if needed > 0 && uint64(needed) > available {
+		return fmt.Errorf("not enough disk space for the update on the file system of [%s], %s needed, but only %s available",
+			path, formatBytes(needed), formatBytes(int64(available)))
+	}
+	return nil
+}
+
+// directorySize returns the total size of the regular files in the given directory tree
+func directorySize(directory string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
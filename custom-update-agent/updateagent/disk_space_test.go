@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestCheckAvailable(t *testing.T) {
+	tests := []struct {
+		name      string
+		needed    int64
+		available uint64
+		err       bool
+	}{
+		{name: "nothing needed", available: 0},
+		{name: "enough space", needed: 100, available: 1000},
+		{name: "exactly enough space", needed: 1000, available: 1000},
+		{name: "not enough space", needed: 1001, available: 1000, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkAvailable("/var/files", test.needed, test.available)
+			if test.err != (err != nil) {
+				t.Errorf("expected error %t, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestDirectorySize(t *testing.T) {
+	directory := t.TempDir()
+	for name, content := range map[string]string{"a.bin": "aaaa", "app/lib/run": "bbbbbb"} {
+		if err := writeTestFile(name, content)(directory); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// symbolic links are not counted
+	if err := os.Symlink("a.bin", filepath.Join(directory, "link")); err != nil {
+		t.Fatal(err)
+	}
+	size, err := directorySize(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10 {
+		t.Errorf("expected size 10, got %d", size)
+	}
+}
+
+func TestDirectoryQuota(t *testing.T) {
+	content := strings.Repeat("a", 1000)
+	tests := []struct {
+		name   string
+		quota  int64
+		status types.StatusType
+	}{
+		{name: "no quota", status: types.StatusIdentified},
+		{name: "within quota", quota: 10000, status: types.StatusIdentified},
+		// the downloaded file and its copy in the staged generation are taken into account
+		{name: "exceeding quota", quota: 1500, status: types.StatusIdentificationFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updMgr, callback := newTestUpdateManager(t)
+			updMgr.config.DirectoryQuota = test.quota
+			server := newTestServer(t, map[string]string{"a.bin": content})
+
+			updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin"}))
+			last := callback.last()
+			if last.status != test.status {
+				t.Fatalf("expected status %s, got %v", test.status, callback.statuses())
+			}
+			if test.status == types.StatusIdentificationFailed && !strings.Contains(last.message, "exceeds its quota") {
+				t.Errorf("expected the quota to be reported, got %q", last.message)
+			}
+		})
+	}
+}
+
+func TestContentSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodHead {
+			t.Errorf("expected only HEAD requests, got %s", request.Method)
+		}
+		writer.Write([]byte("content"))
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name string
+		file *util.File
+		size int64
+	}{
+		{name: "declared size", file: &util.File{Name: "app.bin", DownloadURL: server.URL, Size: 42}, size: 42},
+		{name: "http source", file: &util.File{Name: "app.bin", DownloadURL: server.URL}, size: int64(len("content"))},
+		{name: "source without size resolver", file: &util.File{Name: "app.bin", DownloadURL: "data:,content"}, size: -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size, err := newDownloadClient("", nil).contentSize(context.Background(), test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != test.size {
+				t.Errorf("expected size %d, got %d", test.size, size)
+			}
+		})
+	}
+}
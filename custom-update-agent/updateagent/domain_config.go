@@ -0,0 +1,179 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DomainConfig is the configuration of a single domain of files managed by the agent.
+// Each domain has its own directory, state and journal, so that its update operations are isolated from the other domains.
+type DomainConfig struct {
+	// Name is the domain name used for the communication with the Update Manager, e.g. "files"
+	Name string
+	// Directory is the directory where the files of the domain are managed
+	Directory string
+
+	KeepGenerations     int
+	DirectoryQuota      int64
+	DownloadConcurrency int
+	TrustStore          string
+	RequireSignatures   bool
+	// CredentialsDirectory is the directory with the secrets referenced by the HTTP options of the desired states
+	CredentialsDirectory string
+	ReconcileInterval    time.Duration
+	ReconcilePolicy      string
+}
+
+// NewDomainConfig returns the configuration of the given domain managed in the given directory.
+// Its policies are initialized from the global defaults, e.g. KeepGenerations and ReconcilePolicy.
+func NewDomainConfig(name string, directory string) *DomainConfig {
+	return &DomainConfig{
+		Name:                 name,
+		Directory:            directory,
+		KeepGenerations:      KeepGenerations,
+		DirectoryQuota:       DirectoryQuota,
+		DownloadConcurrency:  DownloadConcurrency,
+		TrustStore:           TrustStore,
+		RequireSignatures:    RequireSignatures,
+		CredentialsDirectory: CredentialsDirectory,
+		ReconcileInterval:    ReconcileInterval,
+		ReconcilePolicy:      ReconcilePolicy,
+	}
+}
+
+// ParseDomainOptions parses the options of a domain in the form name=<domain>,dir=<directory>[,<option>=<value>...].
+// Values containing commas must be enclosed in double quotes, e.g. dir="/var/files,v2".
+func ParseDomainOptions(value string) (map[string]string, error) {
+	options := map[string]string{}
+	for rest, more := value, true; more; {
+		end := strings.IndexAny(rest, ",=")
+		if end < 0 || rest[end] == ',' {
+			option, _, _ := strings.Cut(rest, ",")
+			return nil, fmt.Errorf("invalid domain option %s, expected <option>=<value>, values containing commas must be quoted", option)
+		}
+		key := strings.TrimSpace(rest[:end])
+		rest = rest[end+1:]
+		var optionValue string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.Index(rest[1:], `"`)
+			if closing < 0 {
+				return nil, fmt.Errorf("missing closing quote in the value of domain option %s", key)
+			}
+			optionValue, rest = rest[1:closing+1], rest[closing+2:]
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("unexpected characters after the quoted value of domain option %s", key)
+			}
+			rest, more = strings.CutPrefix(rest, ",")
+		} else {
+			optionValue, rest, more = strings.Cut(rest, ",")
+		}
+		options[key] = optionValue
+	}
+	return options, nil
+}
+
+// NewDomainConfigFromOptions creates the configuration of a domain from its options.
+// The supported options are name, dir, keep-generations, directory-quota, download-concurrency, trust-store, require-signatures, credentials-dir,
+// reconcile-interval and reconcile-policy,
+// the options which are not set keep the global defaults.
+func NewDomainConfigFromOptions(options map[string]string) (*DomainConfig, error) {
+	domain := NewDomainConfig("", "")
+	for key, value := range options {
+		var err error
+		switch key {
+		case "name":
+			domain.Name = value
+		case "dir":
+			domain.Directory = value
+		case "keep-generations":
+			domain.KeepGenerations, err = strconv.Atoi(value)
+		case "directory-quota":
+			domain.DirectoryQuota, err = strconv.ParseInt(value, 10, 64)
+		case "download-concurrency":
+			domain.DownloadConcurrency, err = strconv.Atoi(value)
+		case "trust-store":
+			domain.TrustStore = value
+		case "require-signatures":
+			domain.RequireSignatures, err = strconv.ParseBool(value)
+		case "credentials-dir":
+			domain.CredentialsDirectory = value
+		case "reconcile-interval":
+			domain.ReconcileInterval, err = time.ParseDuration(value)
+		case "reconcile-policy":
+			domain.ReconcilePolicy = value
+		default:
+			return nil, fmt.Errorf("unsupported domain option %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of domain option %s: %w", key, err)
+		}
+	}
+	return domain, domain.validate()
+}
+
+func (d *DomainConfig) validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("domain name is not set")
+	}
+	if d.Directory == "" {
+		return fmt.Errorf("directory of domain %s is not set", d.Name)
+	}
+	if d.DirectoryQuota < 0 {
+		return fmt.Errorf("negative directory quota for domain %s", d.Name)
+	}
+	if d.KeepGenerations < 0 {
+		return fmt.Errorf("negative number of kept generations for domain %s", d.Name)
+	}
+	if d.ReconcilePolicy != ReconcilePolicyReport && d.ReconcilePolicy != ReconcilePolicyRepair {
+		return fmt.Errorf("unsupported reconcile policy %s for domain %s, expected %s or %s", d.ReconcilePolicy, d.Name, ReconcilePolicyReport, ReconcilePolicyRepair)
+	}
+	return nil
+}
+
+// ValidateDomains checks the configurations of all domains managed by the agent.
+// The domain names must be unique and the directories of different domains must not overlap.
+func ValidateDomains(domains []*DomainConfig) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("no domains configured")
+	}
+	directories := make([]string, len(domains))
+	for i, domain := range domains {
+		if err := domain.validate(); err != nil {
+			return err
+		}
+		directory, err := filepath.Abs(domain.Directory)
+		if err != nil {
+			return err
+		}
+		directories[i] = directory
+		for j := 0; j < i; j++ {
+			if domains[j].Name == domain.Name {
+				return fmt.Errorf("domain %s is configured more than once", domain.Name)
+			}
+			if isSubdirectory(directories[j], directory) || isSubdirectory(directory, directories[j]) {
+				return fmt.Errorf("directories of domains %s and %s overlap", domains[j].Name, domain.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func isSubdirectory(parent string, directory string) bool {
+	relative, err := filepath.Rel(parent, directory)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDomainConfigFromOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		check func(*DomainConfig) bool
+		err   string
+	}{
+		{
+			name:  "defaults",
+			value: "name=files,dir=/var/files",
+			check: func(d *DomainConfig) bool {
+				return d.Name == "files" && d.Directory == "/var/files" && d.KeepGenerations == KeepGenerations && d.ReconcilePolicy == ReconcilePolicy
+			},
+		},
+		{
+			name: "all options",
+			value: "name=files,dir=/var/files,keep-generations=3,directory-quota=1024,download-concurrency=2,trust-store=/etc/trust," +
+				"require-signatures=true,credentials-dir=/etc/credentials,reconcile-interval=1m,reconcile-policy=repair",
+			check: func(d *DomainConfig) bool {
+				return d.KeepGenerations == 3 && d.DirectoryQuota == 1024 && d.DownloadConcurrency == 2 && d.TrustStore == "/etc/trust" &&
+					d.RequireSignatures && d.CredentialsDirectory == "/etc/credentials" && d.ReconcileInterval == time.Minute && d.ReconcilePolicy == ReconcilePolicyRepair
+			},
+		},
+		{
+			name:  "quoted directory",
+			value: `name=files,dir="/var/files,v2",trust-store=/etc/trust`,
+			check: func(d *DomainConfig) bool {
+				return d.Directory == "/var/files,v2" && d.TrustStore == "/etc/trust"
+			},
+		},
+		{name: "unquoted comma", value: "name=files,dir=/var/files,v2", err: "invalid domain option v2"},
+		{name: "missing closing quote", value: `name=files,dir="/var/files,v2`, err: "missing closing quote in the value of domain option dir"},
+		{name: "characters after quoted value", value: `name=files,dir="/var/files"v2`, err: "unexpected characters after the quoted value of domain option dir"},
+		{name: "option without value", value: "name=files,dir", err: "invalid domain option dir"},
+		{name: "unsupported option", value: "name=files,dir=/var/files,mode=fast", err: "unsupported domain option mode"},
+		{name: "invalid number", value: "name=files,dir=/var/files,keep-generations=all", err: "invalid value of domain option keep-generations"},
+		{name: "invalid duration", value: "name=files,dir=/var/files,reconcile-interval=1", err: "invalid value of domain option reconcile-interval"},
+		{name: "missing name", value: "dir=/var/files", err: "domain name is not set"},
+		{name: "missing directory", value: "name=files", err: "directory of domain files is not set"},
+		{name: "negative quota", value: "name=files,dir=/var/files,directory-quota=-1", err: "negative directory quota"},
+		{name: "negative generations", value: "name=files,dir=/var/files,keep-generations=-1", err: "negative number of kept generations"},
+		{name: "unsupported policy", value: "name=files,dir=/var/files,reconcile-policy=ignore", err: "unsupported reconcile policy ignore"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := ParseDomainOptions(test.value)
+			var domain *DomainConfig
+			if err == nil {
+				domain, err = NewDomainConfigFromOptions(options)
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(domain) {
+				t.Errorf("unexpected domain configuration %+v", domain)
+			}
+		})
+	}
+}
+
+func TestValidateDomains(t *testing.T) {
+	root := t.TempDir()
+	domain := func(name string, directory string) *DomainConfig {
+		return NewDomainConfig(name, filepath.Join(root, directory))
+	}
+	tests := []struct {
+		name    string
+		domains []*DomainConfig
+		err     string
+	}{
+		{name: "single domain", domains: []*DomainConfig{domain("files", "files")}},
+		{name: "separate directories", domains: []*DomainConfig{domain("files", "files"), domain("config", "config"), domain("data", "files-data")}},
+		{name: "no domains", err: "no domains configured"},
+		{name: "duplicate name", domains: []*DomainConfig{domain("files", "files"), domain("files", "other")}, err: "domain files is configured more than once"},
+		{name: "same directory", domains: []*DomainConfig{domain("files", "files"), domain("config", "files/.")}, err: "directories of domains files and config overlap"},
+		{name: "nested directory", domains: []*DomainConfig{domain("files", "files"), domain("config", "files/config")}, err: "directories of domains files and config overlap"},
+		{name: "parent directory", domains: []*DomainConfig{domain("files", "files/app"), domain("config", "files")}, err: "directories of domains files and config overlap"},
+		{name: "invalid domain", domains: []*DomainConfig{domain("files", "files"), domain("", "config")}, err: "domain name is not set"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateDomains(test.domains)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+const (
+	partialFileSuffix = ".part"
+	partialMetaSuffix = ".part.json"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// partialDownload holds the metadata needed to resume an interrupted download
+type partialDownload struct {
+	URL       string `json:"url"`
+	Validator string `json:"validator"`
+
+	path     string
+	metaPath string
+	offset   int64
+}
+
+// loadPartialDownload returns the state of a previously interrupted download of the given file, if any.
+// The partial content is discarded if it cannot be resumed, e.g. it was downloaded from a different URL.
+func loadPartialDownload(directory string, desired *util.File) *partialDownload {
+	partial := &partialDownload{
+		path:     filepath.Join(directory, desired.Name+partialFileSuffix),
+		metaPath: filepath.Join(directory, desired.Name+partialMetaSuffix),
+	}
+	data, err := os.ReadFile(partial.metaPath)
+	if err == nil {
+		err = json.Unmarshal(data, partial)
+	}
+	if err == nil && partial.URL == desired.DownloadURL && partial.Validator != "" {
+		if info, err := os.Stat(partial.path); err == nil {
+			partial.offset = info.Size()
+			return partial
+		}
+	}
+	partial.reset()
+	return partial
+}
+
+// reset drops any partially downloaded content
+func (p *partialDownload) reset() {
+	p.offset = 0
+	p.Validator = ""
+	os.Remove(p.path)
+	os.Remove(p.metaPath)
+}
+
+func (p *partialDownload) saveMeta(url string, validator string) error {
+	p.URL = url
+	p.Validator = validator
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.metaPath, data, 0644)
+}
+
+// downloadFile downloads the desired file into the download directory.
+// If a previous download of the same file was interrupted, it is resumed from the last written byte,
+// provided that the source still serves the same content.
+// Compressed content is decompressed while downloading, its downloads start from the beginning instead.
+// The download progress is reported to the given file progress.
+func (o *operation) downloadFile(ctx context.Context, desired *util.File, progress *fileProgress) error {
+	return o.downloadFileTo(ctx, o.downloadDirectory, desired, progress)
+}
+
+// downloadFileTo downloads the desired file into the given directory, see downloadFile
+func (o *operation) downloadFileTo(ctx context.Context, directory string, desired *util.File, progress *fileProgress) error {
+	target := filepath.Join(directory, desired.Name)
+	if info, err := os.Stat(target); err == nil {
+		slog.Debug(fmt.Sprintf("file [%s] is already downloaded", desired.Name))
+		progress.begin(info.Size(), info.Size())
+		return nil
+	}
+
+	partial := loadPartialDownload(directory, desired)
+	if partial.offset > 0 && desired.Compression != "" && desired.Compression != util.CompressionNone && desired.Compression != util.CompressionAuto {
+		partial.reset()
+	}
+	if partial.offset > 0 {
+		slog.Debug(fmt.Sprintf("resuming download of file [%s] from byte %d", desired.Name, partial.offset))
+	}
+	resp, err := o.client.fetch(ctx, desired, partial.offset, partial.Validator)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("could not download file from url [%s]", desired.DownloadURL), "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if partial.offset > 0 && resp.Offset != partial.offset {
+		slog.Debug(fmt.Sprintf("cannot resume download of file [%s], starting from the beginning", desired.Name))
+		partial.reset()
+	}
+	total, err := o.client.expectedSize(desired, resp)
+	if err != nil {
+		return err
+	}
+	body := o.client.limitBody(desired, resp.Body, partial.offset, total)
+	content := bufio.NewReader(o.throttle.reader(ctx, body))
+	// resumed downloads were not decompressed by the previous attempt
+	compression := util.CompressionNone
+	if partial.offset == 0 {
+		if compression, err = detectCompression(desired, resp.Encoding, content); err != nil {
+			return err
+		}
+	}
+	validator := resp.Validator
+	if compression != util.CompressionNone {
+		// the position in the compressed content is not known for the decompressed content written so far
+		validator = ""
+	}
+	if err = partial.saveMeta(desired.DownloadURL, validator); err != nil {
+		slog.Debug(fmt.Sprintf("could not store download metadata of file [%s]", desired.Name), "error", err)
+		return err
+	}
+
+	out, err := os.OpenFile(partial.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("could not create file [%s]", desired.Name), "error", err)
+		return err
+	}
+	defer out.Close()
+
+	verifier := newChecksumVerifier(desired)
+	// the digest announced by the source applies to the transferred content, which differs from the decompressed one
+	transferVerifier := verifier
+	if compression != util.CompressionNone {
+		transferVerifier = &checksumVerifier{fileName: desired.Name}
+	}
+	if err = transferVerifier.expect(resp.Digest); err != nil {
+		return err
+	}
+	// the checksums are calculated over the whole content, including the already downloaded part
+	if _, err = io.CopyN(verifier.writer(), out, partial.offset); err != nil {
+		slog.Debug(fmt.Sprintf("could not read partially downloaded file [%s]", desired.Name), "error", err)
+		partial.reset()
+		return err
+	}
+	if err = out.Truncate(partial.offset); err != nil {
+		return err
+	}
+	progress.begin(partial.offset, total)
+	_, err = o.copyContent(io.MultiWriter(out, verifier.writer()), io.TeeReader(content, progress), desired, compression, transferVerifier)
+	if err == nil {
+		err = body.verify()
+	}
+	if err == nil && transferVerifier != verifier {
+		err = transferVerifier.verify()
+	}
+	if err != nil {
+		slog.Debug(fmt.Sprintf("could not copy contents from [%s] to file [%s]", desired.DownloadURL, desired.Name), "error", err)
+		return err
+	}
+	if err = verifier.verify(); err != nil {
+		slog.Debug(fmt.Sprintf("could not verify downloaded file [%s]", desired.Name), "error", err)
+		partial.reset()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(partial.path, target); err != nil {
+		slog.Debug(fmt.Sprintf("could not move downloaded file [%s]", desired.Name), "error", err)
+		return err
+	}
+	os.Remove(partial.metaPath)
+	progress.done()
+	return nil
+}
+
+// copyContent copies the downloaded content to the given writer, decompressing it with the given compression.
+// The decompressed content must not exceed the maximum file size either.
+func (o *operation) copyContent(writer io.Writer, content io.Reader, desired *util.File, compression string, transferVerifier *checksumVerifier) (int64, error) {
+	if compression == util.CompressionNone {
+		return io.Copy(writer, content)
+	}
+	decompressor, err := newDecompressor(io.TeeReader(content, transferVerifier.writer()), compression)
+	if err != nil {
+		return 0, fmt.Errorf("cannot decompress %s content of file [%s]: %w", compression, desired.Name, err)
+	}
+	defer decompressor.Close()
+	return io.Copy(writer, &sizeLimitedReader{reader: decompressor, fileName: desired.Name, expected: -1, limit: o.client.maxFileSize})
+}
+
+// sanitizeFileName replaces all characters not safe for usage in a file name
+func sanitizeFileName(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return unsafeFileNameChars.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// CacheDirectory is the directory of the content-addressed cache of downloaded files, which is shared by all domains.
+// Files with a sha256 or sha512 checksum are taken from the cache instead of downloading them again. The cache is disabled if not set.
+var CacheDirectory string
+
+// CacheSize is the maximum total size of the cached files in bytes, the least recently used files are removed once it is exceeded, 0 means no limit
+var CacheSize int64 = 1 << 30
+
+// cacheLock guards the cache, so that files are not removed while they are looked up or added
+var cacheLock sync.Mutex
+
+// cacheKeys returns the paths of the content of the given file in the cache, relative to the cache directory, e.g. sha256/<hex>
+func cacheKeys(file *util.File) []string {
+	keys := []string{}
+	if file.SHA256 != "" {
+		keys = append(keys, filepath.Join("sha256", file.SHA256))
+	}
+	if file.SHA512 != "" {
+		keys = append(keys, filepath.Join("sha512", file.SHA512))
+	}
+	return keys
+}
+
+// lookupCache returns the path of the cached content of the given file, or empty if it is not cached.
+// The content is marked as recently used.
+func lookupCache(file *util.File) string {
+	if CacheDirectory == "" {
+		return ""
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	for _, key := range cacheKeys(file) {
+		path := filepath.Join(CacheDirectory, key)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return path
+		}
+	}
+	return ""
+}
+
+// addToCache stores the verified content of the given file at the given path in the cache.
+// The content is linked into the cache if possible, otherwise it is copied. The least recently used files are removed afterwards.
+func addToCache(file *util.File, path string) {
+	keys := cacheKeys(file)
+	if CacheDirectory == "" || len(keys) == 0 {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || (CacheSize > 0 && info.Size() > CacheSize) {
+		return
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	target := filepath.Join(CacheDirectory, keys[0])
+	if _, err = os.Stat(target); err == nil {
+		now := time.Now()
+		os.Chtimes(target, now, now)
+		return
+	}
+	if err = storeInCache(path, target); err != nil {
+		slog.Warn(fmt.Sprintf("cannot add file [%s] to the download cache", file.Name), "error", err)
+		return
+	}
+	slog.Debug(fmt.Sprintf("file [%s] added to the download cache", file.Name))
+	if err = evictCache(); err != nil {
+		slog.Warn("cannot remove the least recently used files from the download cache", "error", err)
+	}
+}
+
+// storeInCache places the content at the given path as the given cache entry, using a temporary file, so that the cache never holds partial content
+func storeInCache(path string, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	temp := target + partialFileSuffix
+	os.Remove(temp)
+	if err := os.Link(path, temp); err != nil {
+		// e.g. the temporary directory is on another file system
+		if err = copyRegularFile(path, temp, 0644); err != nil {
+			os.Remove(temp)
+			return err
+		}
+	}
+	now := time.Now()
+	os.Chtimes(temp, now, now)
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// evictCache removes the least recently used files from the cache, until their total size no longer exceeds the cache size
+func evictCache() error {
+	if CacheSize <= 0 {
+		return nil
+	}
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	entries := []entry{}
+	var total int64
+	err := filepath.WalkDir(CacheDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, partialFileSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= CacheSize {
+			break
+		}
+		slog.Debug(fmt.Sprintf("removing [%s] from the download cache", filepath.Base(e.path)))
+		if err = os.Remove(e.path); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// removeFromCache removes a cache entry whose content does not match its digest
+func removeFromCache(path string) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	os.Remove(path)
+}
+
+// copyLocalFile copies the content of the desired file of the action into the download directory from a local source instead of downloading it.
+// The content is taken from an installed file with the same digest or from the download cache, and it is verified against the checksums of the desired file.
+// It returns false if there is no such local content.
+func (o *operation) copyLocalFile(baselineAction *action, action *fileAction, progress *fileProgress) bool {
+	desired := action.desired
+	target := filepath.Join(o.downloadDirectory, desired.Name)
+	if _, err := os.Stat(target); err == nil {
+		// already downloaded by a previous attempt
+		return false
+	}
+	if desired.SHA256 != "" && !desired.IsArchive() {
+		generationDirectory := o.directory.generationDirectory(o.previousGeneration)
+		for _, record := range o.currentState.Files {
+			if record.IsArchive() || record.Drift != "" || record.Digest != "sha256:"+desired.SHA256 {
+				continue
+			}
+			if err := copyVerified(installedPath(generationDirectory, &record.File), target, desired); err != nil {
+				slog.Debug(fmt.Sprintf("[%s] cannot copy installed file [%s]", desired.Name, record.Name), "error", err)
+				continue
+			}
+			o.copiedLocalFile(baselineAction, action, progress, target, fmt.Sprintf("File copied from installed file %s.", record.Name))
+			return true
+		}
+	}
+	if cached := lookupCache(desired); cached != "" {
+		if err := copyVerified(cached, target, desired); err != nil {
+			slog.Warn(fmt.Sprintf("[%s] cannot copy file from the download cache", desired.Name), "error", err)
+			removeFromCache(cached)
+			return false
+		}
+		o.copiedLocalFile(baselineAction, action, progress, target, "File taken from the download cache.")
+		return true
+	}
+	return false
+}
+
+func (o *operation) copiedLocalFile(baselineAction *action, action *fileAction, progress *fileProgress, target string, message string) {
+	slog.Debug(fmt.Sprintf("[%s] %s", action.desired.Name, message))
+	if info, err := os.Stat(target); err == nil {
+		progress.begin(info.Size(), info.Size())
+	}
+	o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloading, message)
+}
+
+// copyVerified copies the content at the given source path to the target path, provided that it matches the checksums of the desired file
+func copyVerified(source string, target string, desired *util.File) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	partial := target + partialFileSuffix
+	out, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	verifier := newChecksumVerifier(desired)
+	_, err = io.Copy(io.MultiWriter(out, verifier.writer()), in)
+	if err == nil {
+		err = verifier.verify()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = os.Rename(partial, target)
+	}
+	if err != nil {
+		os.Remove(partial)
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func setTestCacheDirectory(t *testing.T, directory string) {
+	t.Helper()
+	cacheDirectory := CacheDirectory
+	CacheDirectory = directory
+	t.Cleanup(func() { CacheDirectory = cacheDirectory })
+}
+
+func TestDownloadCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum bool
+		corrupt  bool
+		download bool
+	}{
+		{name: "cached", checksum: true},
+		{name: "corrupt cache entry", checksum: true, corrupt: true, download: true},
+		{name: "without checksum", download: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := t.TempDir()
+			setTestCacheDirectory(t, cache)
+			desiredState := func(url string) *types.DesiredState {
+				ds := newTestDesiredState(map[string]string{"a.bin": url + "/a.bin"})
+				if test.checksum {
+					withComponentConfig(ds, "a.bin", &types.KeyValuePair{Key: "sha256", Value: sha256Hex(testContent)})
+				}
+				return ds
+			}
+
+			// the cache is shared by the domains
+			first, firstCallback := newTestUpdateManager(t)
+			firstServer := newTestServer(t, map[string]string{"a.bin": testContent})
+			installTestFiles(t, first, firstCallback, "first", desiredState(firstServer.URL))
+			entry := filepath.Join(cache, "sha256", sha256Hex(testContent))
+			if test.corrupt {
+				if err := os.Remove(entry); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(entry, []byte("corrupt"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			second, secondCallback := newTestUpdateManager(t)
+			secondServer, downloaded := newRecordingTestServer(t, map[string]string{"a.bin": testContent})
+			installTestFiles(t, second, secondCallback, "second", desiredState(secondServer.URL))
+			if downloaded("/a.bin") != test.download {
+				t.Errorf("expected the file to be downloaded %t, got %t", test.download, !test.download)
+			}
+			content, err := os.ReadFile(filepath.Join(second.directory.currentDirectory(), "a.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testContent {
+				t.Errorf("expected content %q, got %q", testContent, content)
+			}
+			if test.checksum {
+				// the corrupt entry is replaced with the downloaded file
+				if cached, err := os.ReadFile(entry); err != nil || string(cached) != testContent {
+					t.Errorf("expected the cache entry with content %q, got %q (%v)", testContent, cached, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyFromInstalledFile(t *testing.T) {
+	setTestCacheDirectory(t, "")
+	updMgr, callback := newTestUpdateManager(t)
+	server, downloaded := newRecordingTestServer(t, map[string]string{"a.bin": testContent, "b.bin": testContent})
+	checksum := &types.KeyValuePair{Key: "sha256", Value: sha256Hex(testContent)}
+
+	installTestFiles(t, updMgr, callback, "first", withComponentConfig(newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin"}), "a.bin", checksum))
+	desiredState := newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin", "b.bin": server.URL + "/b.bin"})
+	installTestFiles(t, updMgr, callback, "second", withComponentConfig(withComponentConfig(desiredState, "a.bin", checksum), "b.bin", checksum))
+
+	if downloaded("/b.bin") {
+		t.Error("expected the file to be copied from the installed file with the same checksum")
+	}
+	content, err := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), "b.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != testContent {
+		t.Errorf("expected content %q, got %q", testContent, content)
+	}
+}
+
+func TestEvictCache(t *testing.T) {
+	cacheSize := CacheSize
+	CacheSize = 10
+	defer func() { CacheSize = cacheSize }()
+
+	tests := []struct {
+		name    string
+		used    string
+		evicted string
+	}{
+		{name: "least recently added", evicted: "a"},
+		{name: "least recently used", used: "a", evicted: "b"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestCacheDirectory(t, t.TempDir())
+			start := time.Now().Add(-time.Hour)
+			for i, name := range []string{"a", "b", "c"} {
+				path := filepath.Join(CacheDirectory, "sha256", name)
+				if err := writeTestFile(filepath.Join("sha256", name), "1234")(CacheDirectory); err != nil {
+					t.Fatal(err)
+				}
+				modTime := start.Add(time.Duration(i) * time.Minute)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.used != "" && lookupCache(&util.File{SHA256: test.used}) == "" {
+				t.Fatalf("expected file [%s] to be cached", test.used)
+			}
+
+			if err := evictCache(); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				_, err := os.Stat(filepath.Join(CacheDirectory, "sha256", name))
+				if evicted := errors.Is(err, fs.ErrNotExist); evicted != (name == test.evicted) {
+					t.Errorf("expected file [%s] to be evicted %t, got %t", name, name == test.evicted, evicted)
+				}
+			}
+		})
+	}
+}
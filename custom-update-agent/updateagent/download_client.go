@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+// MaxFileSize is the maximum size in bytes of a single downloaded file, 0 means no limit
+var MaxFileSize int64
+
+// downloadClient retrieves the content of desired files from their sources and validates the received content
+type downloadClient struct {
+	maxFileSize int64
+
+	credentialsDirectory string
+	httpOptions          map[string]string
+}
+
+// newDownloadClient returns a client for the files of a desired state with the given domain HTTP options
+func newDownloadClient(credentialsDirectory string, httpOptions map[string]string) *downloadClient {
+	return &downloadClient{
+		maxFileSize:          MaxFileSize,
+		credentialsDirectory: credentialsDirectory,
+		httpOptions:          httpOptions,
+	}
+}
+
+// httpConfig returns the HTTP settings of the desired file, its component HTTP options take precedence over the domain ones
+func (c *downloadClient) httpConfig(desired *util.File) (*HTTPConfig, error) {
+	config, err := newHTTPConfig(c.credentialsDirectory, c.httpOptions, desired.HTTPOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP options for file [%s]: %w", desired.Name, err)
+	}
+	return config, nil
+}
+
+// fetch opens the content of the desired file using the fetcher registered for the scheme of its download URL.
+// If offset is positive, only the content after it is requested, as long as the source still matches the given validator.
+func (c *downloadClient) fetch(ctx context.Context, desired *util.File, offset int64, validator string) (*FetchResponse, error) {
+	location, err := url.Parse(desired.DownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := fetcherFor(location)
+	if err != nil {
+		return nil, err
+	}
+	httpConfig, err := c.httpConfig(desired)
+	if err != nil {
+		return nil, err
+	}
+	return fetcher.Fetch(ctx, &FetchRequest{
+		URL:       location,
+		Name:      desired.Name,
+		Offset:    offset,
+		Validator: validator,
+		HTTP:      httpConfig,
+	})
+}
+
+// contentSize returns the size of the desired file, or -1 if it is unknown.
+// The declared size is preferred, otherwise the size is requested from the source without downloading the content, if supported by its fetcher.
+// The size is unknown for sources, whose fetchers cannot resolve it.
+func (c *downloadClient) contentSize(ctx context.Context, desired *util.File) (int64, error) {
+	if desired.Size > 0 {
+		return desired.Size, nil
+	}
+	location, err := url.Parse(desired.DownloadURL)
+	if err != nil {
+		return -1, err
+	}
+	fetcher, err := fetcherFor(location)
+	if err != nil {
+		return -1, err
+	}
+	httpConfig, err := c.httpConfig(desired)
+	if err != nil {
+		return -1, err
+	}
+	request := &FetchRequest{URL: location, Name: desired.Name, HTTP: httpConfig}
+	if resolver, ok := fetcher.(SizeResolver); ok {
+		return resolver.Size(ctx, request)
+	}
+	return -1, nil
+}
+
+// expectedSize returns the total size of the desired file, or -1 if it is unknown.
+// The size announced by the source must match the declared size of the file and must not exceed the maximum file size.
+func (c *downloadClient) expectedSize(desired *util.File, resp *FetchResponse) (int64, error) {
+	total := resp.Size
+	if desired.Size > 0 {
+		if total >= 0 && total != desired.Size {
+			return -1, fmt.Errorf("size mismatch for file [%s], expected %d bytes, but the source announced %d bytes", desired.Name, desired.Size, total)
+		}
+		total = desired.Size
+	}
+	if c.maxFileSize > 0 && total > c.maxFileSize {
+		return -1, fmt.Errorf("file [%s] with size of %d bytes exceeds the maximum file size of %d bytes", desired.Name, total, c.maxFileSize)
+	}
+	return total, nil
+}
+
+// limitBody wraps the response body, so that reading fails once more than the expected (or maximum allowed) bytes are received
+func (c *downloadClient) limitBody(desired *util.File, body io.Reader, offset int64, total int64) *sizeLimitedReader {
+	return &sizeLimitedReader{
+		reader:   body,
+		fileName: desired.Name,
+		read:     offset,
+		expected: total,
+		limit:    c.maxFileSize,
+	}
+}
+
+// sizeLimitedReader counts the bytes of a file being downloaded and checks them against its expected size
+type sizeLimitedReader struct {
+	reader   io.Reader
+	fileName string
+	read     int64
+	expected int64
+	limit    int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.expected >= 0 && r.read > r.expected {
+		return n, fmt.Errorf("received more than the expected %d bytes for file [%s]", r.expected, r.fileName)
+	}
+	if r.limit > 0 && r.read > r.limit {
+		return n, fmt.Errorf("file [%s] exceeds the maximum file size of %d bytes", r.fileName, r.limit)
+	}
+	return n, err
+}
+
+// verify checks that the whole file content is received, if its size is known
+func (r *sizeLimitedReader) verify() error {
+	if r.expected >= 0 && r.read != r.expected {
+		return fmt.Errorf("file [%s] is truncated, received %d of %d bytes: %w", r.fileName, r.read, r.expected, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// fetchContent reads the whole content at the given location, e.g. a detached signature of the desired file, failing if it exceeds the given limit in bytes.
+// The HTTP settings of the desired file are applied.
+func (c *downloadClient) fetchContent(ctx context.Context, desired *util.File, location string, name string, limit int64) ([]byte, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := fetcherFor(parsed)
+	if err != nil {
+		return nil, err
+	}
+	httpConfig, err := c.httpConfig(desired)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetcher.Fetch(ctx, &FetchRequest{URL: parsed, Name: name, HTTP: httpConfig})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("content of [%s] exceeds the maximum size of %d bytes", name, limit)
+	}
+	return content, nil
+}
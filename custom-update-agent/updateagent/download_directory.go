@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const downloadsDirectoryName = ".downloads"
+
+// downloadsDirectory returns the directory holding the temporary directories of the update operations.
+// It is kept in the files directory, so that it is owned by the agent and the downloaded files are on the file system they are installed to.
+func (d filesDirectory) downloadsDirectory() string {
+	return filepath.Join(string(d), downloadsDirectoryName)
+}
+
+// operationDirectory returns the temporary directory of the operation with the given activity ID
+func (d filesDirectory) operationDirectory(activityID string) string {
+	return filepath.Join(d.downloadsDirectory(), sanitizeFileName(activityID))
+}
+
+// removeStaleDownloads removes the temporary directories of all operations except the one with the given activity ID
+func (d filesDirectory) removeStaleDownloads(activityID string) {
+	entries, err := os.ReadDir(d.downloadsDirectory())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("got error reading downloads directory", "error", err)
+		}
+		return
+	}
+	keep := filepath.Base(d.operationDirectory(activityID))
+	for _, entry := range entries {
+		if activityID != "" && entry.Name() == keep {
+			continue
+		}
+		slog.Debug(fmt.Sprintf("removing stale temporary directory [%s]", entry.Name()))
+		if err = os.RemoveAll(filepath.Join(d.downloadsDirectory(), entry.Name())); err != nil {
+			slog.Error("got error removing stale temporary directory", "error", err)
+		}
+	}
+}
+
+// makePrivateDirectory creates a directory accessible only by the agent.
+// An existing directory is reused only if it is not a symbolic link and is accessible only by the agent.
+func makePrivateDirectory(path string) error {
+	err := os.Mkdir(path, 0700)
+	if err == nil || !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("[%s] is not a directory", path)
+	}
+	if err = checkPrivateDirectory(info); err != nil {
+		return fmt.Errorf("directory [%s] is not private to the agent: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMakePrivateDirectory(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare creates the existing file at the path, if any
+		prepare func(t *testing.T, path string) error
+		err     bool
+		// unix marks cases relying on the ownership and mode checks, which are done on Linux and macOS only
+		unix bool
+	}{
+		{name: "new directory", prepare: func(*testing.T, string) error { return nil }},
+		{name: "private directory", prepare: func(_ *testing.T, path string) error { return os.Mkdir(path, 0700) }},
+		{
+			name: "directory accessible by others",
+			prepare: func(_ *testing.T, path string) error {
+				if err := os.Mkdir(path, 0700); err != nil {
+					return err
+				}
+				return os.Chmod(path, 0777)
+			},
+			err:  true,
+			unix: true,
+		},
+		{
+			name: "symbolic link to a private directory",
+			prepare: func(t *testing.T, path string) error {
+				target := filepath.Join(t.TempDir(), "target")
+				if err := os.Mkdir(target, 0700); err != nil {
+					return err
+				}
+				return os.Symlink(target, path)
+			},
+			err: true,
+		},
+		{name: "file", prepare: func(_ *testing.T, path string) error { return os.WriteFile(path, nil, 0600) }, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.unix && runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+				t.Skip("ownership and mode are checked on Linux and macOS only")
+			}
+			path := filepath.Join(t.TempDir(), "private")
+			if err := test.prepare(t, path); err != nil {
+				t.Fatal(err)
+			}
+			err := makePrivateDirectory(path)
+			if test.err {
+				if err == nil {
+					t.Error("expected the directory to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+				t.Errorf("expected mode 700, got %o", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestStaleDownloadsRemoved(t *testing.T) {
+	updMgr, _ := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a"})
+	stale := filepath.Join(updMgr.directory.operationDirectory("stale"), "file_agent_download")
+	if err := os.MkdirAll(stale, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}))
+
+	if _, err := os.Stat(updMgr.directory.operationDirectory("stale")); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary directory of another activity to be removed, got %v", err)
+	}
+	if _, err := os.Stat(updMgr.directory.operationDirectory("activity")); err != nil {
+		t.Errorf("expected the temporary directory of the operation in progress, got %v", err)
+	}
+
+	// a desired state without actions keeps the operation in progress
+	updMgr.Apply(context.Background(), "other", newTestDesiredState(map[string]string{}))
+	if _, err := os.Stat(updMgr.directory.operationDirectory("activity")); err != nil {
+		t.Errorf("expected the temporary directory of the operation in progress to be kept, got %v", err)
+	}
+	if _, err := os.Stat(updMgr.directory.operationDirectory("other")); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary directory of the desired state without actions to be removed, got %v", err)
+	}
+}
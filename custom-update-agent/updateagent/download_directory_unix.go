@@ -0,0 +1,33 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build linux || darwin
+
+package updateagent
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDirectory checks that the directory is owned by the user of the agent and not accessible by others
+func checkPrivateDirectory(info fs.FileInfo) error {
+	if owner := info.Sys().(*syscall.Stat_t).Uid; int(owner) != os.Getuid() {
+		return fmt.Errorf("owned by user %d", owner)
+	}
+	if mode := info.Mode().Perm(); mode != 0700 {
+		return fmt.Errorf("has mode %o", mode)
+	}
+	return nil
+}
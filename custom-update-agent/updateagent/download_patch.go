@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	patchDirectoryName = "file_agent_patches"
+
+	bsdiffMagic = "BSDIFF40"
+	// zstdMaxWindow is the maximum window size of zstd patches, the largest one supported by zstd --patch-from
+	zstdMaxWindow = 1 << 31
+)
+
+var errCorruptPatch = errors.New("corrupt patch")
+
+// downloadPatch rebuilds the desired file of the action from the installed file and its binary patch, if the installed file matches the patch base.
+// The rebuilt file is stored in the download directory and verified against the checksums of the desired file.
+// It returns false if the file has to be downloaded as a whole instead, e.g. the installed file was modified or the patch cannot be applied.
+func (o *operation) downloadPatch(ctx context.Context, baselineAction *action, action *fileAction, progress *fileProgress) bool {
+	desired := action.desired
+	if !desired.HasPatch() || action.current == nil || action.current.IsArchive() {
+		return false
+	}
+	target := filepath.Join(o.downloadDirectory, desired.Name)
+	if _, err := os.Stat(target); err == nil {
+		// already downloaded or rebuilt by a previous attempt
+		return false
+	}
+	base := installedPath(o.directory.generationDirectory(o.previousGeneration), action.current)
+	digest, err := fileSHA256(base)
+	if err != nil || hex.EncodeToString(digest) != desired.BaseSHA256 {
+		slog.Info(fmt.Sprintf("[%s] installed file does not match the base of the patch, downloading the whole file", desired.Name))
+		return false
+	}
+
+	patchDirectory := filepath.Join(o.temporaryDirectory, patchDirectoryName)
+	patch := &util.File{Name: desired.Name, DownloadURL: desired.PatchURL, HTTPOptions: desired.HTTPOptions, Compression: util.CompressionNone}
+	o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloading, "Downloading patch of the installed file.")
+	err = os.MkdirAll(patchDirectory, 0755)
+	if err == nil {
+		err = o.retryDownload(ctx, baselineAction, action, func() error {
+			return o.downloadFileTo(ctx, patchDirectory, patch, progress)
+		})
+	}
+	if err == nil {
+		err = applyPatch(base, filepath.Join(patchDirectory, patch.Name), target, desired, o.client.maxFileSize)
+		// a patch which cannot be applied is not used again
+		os.Remove(filepath.Join(patchDirectory, patch.Name))
+	}
+	if err != nil {
+		// the partially downloaded patch is kept, so that it is resumed with the download
+		if ctx.Err() != nil || errors.Is(err, errDownloadWindowClosed) {
+			return false
+		}
+		message := fmt.Sprintf("Patch cannot be applied: %v. Downloading the whole file.", err)
+		slog.Warn(fmt.Sprintf("[%s] %s", desired.Name, message))
+		o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloading, message)
+		return false
+	}
+	slog.Debug(fmt.Sprintf("[%s] file rebuilt from patch", desired.Name))
+	return true
+}
+
+// applyPatch rebuilds the desired file at the target path from the base file and the patch, which is either in bsdiff or in zstd --patch-from format.
+// The rebuilt file must match the checksums of the desired file and must not exceed the given limit in bytes, 0 means no limit.
+func applyPatch(basePath string, patchPath string, target string, desired *util.File, limit int64) error {
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+	magic := make([]byte, len(bsdiffMagic))
+	if _, err = io.ReadFull(patch, magic); err != nil {
+		return errCorruptPatch
+	}
+	if _, err = patch.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	partial := target + partialFileSuffix
+	out, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	verifier := newChecksumVerifier(desired)
+	writer := io.MultiWriter(out, verifier.writer())
+	switch {
+	case bytes.Equal(magic, []byte(bsdiffMagic)):
+		err = bspatch(base, patch, writer, limit)
+	case bytes.HasPrefix(magic, magicZstd):
+		err = zstdPatch(basePath, patch, writer, limit)
+	default:
+		err = errors.New("unsupported patch format, expected bsdiff or zstd")
+	}
+	if err == nil {
+		err = verifier.verify()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = os.Rename(partial, target)
+	}
+	if err != nil {
+		os.Remove(partial)
+	}
+	return err
+}
+
+// bspatch applies a patch in the BSDIFF40 format: a header with the sizes of the control and diff blocks and of the new file,
+// followed by the bzip2 compressed control, diff and extra blocks. The base file is read at the offsets given by the control block.
+func bspatch(base *os.File, patch *os.File, writer io.Writer, limit int64) error {
+	baseInfo, err := base.Stat()
+	if err != nil {
+		return err
+	}
+	patchInfo, err := patch.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 32)
+	if _, err = io.ReadFull(patch, header); err != nil {
+		return errCorruptPatch
+	}
+	controlSize, diffSize, newSize := offtin(header[8:]), offtin(header[16:]), offtin(header[24:])
+	if controlSize < 0 || diffSize < 0 || newSize < 0 || 32+controlSize+diffSize > patchInfo.Size() {
+		return errCorruptPatch
+	}
+	if limit > 0 && newSize > limit {
+		return fmt.Errorf("patched file with size of %d bytes exceeds the maximum file size of %d bytes", newSize, limit)
+	}
+	control := bzip2.NewReader(io.NewSectionReader(patch, 32, controlSize))
+	diff := bzip2.NewReader(io.NewSectionReader(patch, 32+controlSize, diffSize))
+	extraOffset := 32 + controlSize + diffSize
+	extra := bzip2.NewReader(io.NewSectionReader(patch, extraOffset, patchInfo.Size()-extraOffset))
+
+	buffer := make([]byte, 32*1024)
+	baseBuffer := make([]byte, len(buffer))
+	entry := make([]byte, 24)
+	var basePos, newPos int64
+	for newPos < newSize {
+		if _, err = io.ReadFull(control, entry); err != nil {
+			return errCorruptPatch
+		}
+		diffLength, extraLength, seek := offtin(entry), offtin(entry[8:]), offtin(entry[16:])
+		if diffLength < 0 || extraLength < 0 || newPos+diffLength+extraLength > newSize {
+			return errCorruptPatch
+		}
+		// the diff bytes are added to the base bytes, base bytes outside of the base file are zero
+		for remaining := diffLength; remaining > 0; {
+			n := int(min(remaining, int64(len(buffer))))
+			if _, err = io.ReadFull(diff, buffer[:n]); err != nil {
+				return errCorruptPatch
+			}
+			if err = readBase(base, baseInfo.Size(), basePos, baseBuffer[:n]); err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				buffer[i] += baseBuffer[i]
+			}
+			if _, err = writer.Write(buffer[:n]); err != nil {
+				return err
+			}
+			basePos += int64(n)
+			remaining -= int64(n)
+		}
+		newPos += diffLength
+		if _, err = io.CopyN(writer, extra, extraLength); err != nil {
+			return errCorruptPatch
+		}
+		newPos += extraLength
+		basePos += seek
+	}
+	return nil
+}
+
+// readBase fills the buffer with the base file content starting at the given position, using zeros outside of the base file
+func readBase(base io.ReaderAt, baseSize int64, position int64, buffer []byte) error {
+	clear(buffer)
+	start := max(position, 0)
+	end := min(position+int64(len(buffer)), baseSize)
+	if start >= end {
+		return nil
+	}
+	_, err := base.ReadAt(buffer[start-position:end-position], start)
+	return err
+}
+
+// offtin decodes a signed 64-bit integer in the sign-magnitude little endian format of bsdiff
+func offtin(data []byte) int64 {
+	value := int64(binary.LittleEndian.Uint64(data) &^ (1 << 63))
+	if data[7]&0x80 != 0 {
+		value = -value
+	}
+	return value
+}
+
+// zstdPatch applies a patch created with zstd --patch-from, i.e. zstd frames compressed with the whole base file as a raw dictionary
+func zstdPatch(basePath string, patch io.Reader, writer io.Writer, limit int64) error {
+	base, err := os.ReadFile(basePath)
+	if err != nil {
+		return err
+	}
+	// the window of the patch covers the whole base file
+	reader, err := newZstdReader(patch, zstd.WithDecoderDictRaw(0, base), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var content io.Reader = reader
+	if limit > 0 {
+		content = io.LimitReader(reader, limit+1)
+	}
+	written, err := io.Copy(writer, content)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && written > limit {
+		return fmt.Errorf("patched file exceeds the maximum file size of %d bytes", limit)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	testPatchBase   = "the quick brown fox jumps over the lazy dog"
+	testPatchedBase = "the quick brown cat jumps over the lazy dog!!"
+	testBsdiffPatch = "42534449464634302b000000000000002e000000000000002d00000000000000425a6839314159265359e64bed0f000005d00058080008200030cd00901a" +
+		"41566e2ee48a70a121cc97da1e425a6839314159265359bd85e4eb0000006000c0040400100620002128d36a0c025802be2ee48a70a1217b0bc9d6425a6839" +
+		"3141592653599110c72f000000900020002000211846c2ee48a70a12122218e5e0"
+)
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// zstdTestPatch creates a patch of the given base in the format of zstd --patch-from
+func zstdTestPatch(t *testing.T, base string, content string) []byte {
+	t.Helper()
+	buffer := &bytes.Buffer{}
+	writer, err := zstd.NewWriter(buffer, zstd.WithEncoderDictRaw(0, []byte(base)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestApplyPatch(t *testing.T) {
+	bsdiffPatch, _ := hex.DecodeString(testBsdiffPatch)
+	zstdPatch := zstdTestPatch(t, testPatchBase, testPatchedBase)
+	tests := []struct {
+		name   string
+		patch  []byte
+		sha256 string
+		limit  int64
+		err    bool
+	}{
+		{name: "bsdiff", patch: bsdiffPatch},
+		{name: "zstd", patch: zstdPatch},
+		{name: "bsdiff within limit", patch: bsdiffPatch, limit: int64(len(testPatchedBase))},
+		{name: "bsdiff exceeding limit", patch: bsdiffPatch, limit: int64(len(testPatchedBase)) - 1, err: true},
+		{name: "zstd exceeding limit", patch: zstdPatch, limit: int64(len(testPatchedBase)) - 1, err: true},
+		{name: "checksum mismatch", patch: bsdiffPatch, sha256: sha256Hex(testPatchBase), err: true},
+		{name: "truncated bsdiff", patch: bsdiffPatch[:len(bsdiffPatch)-20], err: true},
+		{name: "truncated header", patch: bsdiffPatch[:20], err: true},
+		{name: "unsupported format", patch: []byte("PATCH v1 " + testPatchedBase), err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			base := filepath.Join(directory, "base")
+			patch := filepath.Join(directory, "patch")
+			target := filepath.Join(directory, "target")
+			if err := os.WriteFile(base, []byte(testPatchBase), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(patch, test.patch, 0644); err != nil {
+				t.Fatal(err)
+			}
+			desired := &util.File{Name: "app.bin", SHA256: test.sha256}
+			if desired.SHA256 == "" {
+				desired.SHA256 = sha256Hex(testPatchedBase)
+			}
+
+			err := applyPatch(base, patch, target, desired, test.limit)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected the patch to be rejected")
+				}
+				entries, _ := os.ReadDir(directory)
+				if len(entries) != 2 {
+					t.Errorf("expected no patched or partial file to be left, got %d entries", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testPatchedBase {
+				t.Errorf("expected content %q, got %q", testPatchedBase, content)
+			}
+		})
+	}
+}
+
+func TestDownloadPatch(t *testing.T) {
+	bsdiffPatch, _ := hex.DecodeString(testBsdiffPatch)
+	tests := []struct {
+		name     string
+		modify   bool
+		patch    string
+		download bool
+	}{
+		{name: "patched", patch: string(bsdiffPatch)},
+		{name: "modified installed file", modify: true, patch: string(bsdiffPatch), download: true},
+		{name: "corrupt patch", patch: string(bsdiffPatch[:len(bsdiffPatch)-20]), download: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updMgr, callback := newTestUpdateManager(t)
+			server, downloaded := newRecordingTestServer(t, map[string]string{"v1/a.bin": testPatchBase, "v2/a.bin": testPatchedBase, "a.patch": test.patch})
+
+			installTestFiles(t, updMgr, callback, "first", withComponentConfig(newTestDesiredState(map[string]string{"a.bin": server.URL + "/v1/a.bin"}), "a.bin",
+				&types.KeyValuePair{Key: "sha256", Value: sha256Hex(testPatchBase)}))
+			if test.modify {
+				if err := os.WriteFile(filepath.Join(updMgr.directory.currentDirectory(), "a.bin"), []byte("modified"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			installTestFiles(t, updMgr, callback, "second", withComponentConfig(newTestDesiredState(map[string]string{"a.bin": server.URL + "/v2/a.bin"}), "a.bin",
+				&types.KeyValuePair{Key: "sha256", Value: sha256Hex(testPatchedBase)},
+				&types.KeyValuePair{Key: "patch_url", Value: server.URL + "/a.patch"},
+				&types.KeyValuePair{Key: "base_sha256", Value: sha256Hex(testPatchBase)}))
+
+			content, err := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), "a.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != testPatchedBase {
+				t.Errorf("expected content %q, got %q", testPatchedBase, content)
+			}
+			if downloaded("/v2/a.bin") != test.download {
+				t.Errorf("expected the whole file to be downloaded %t, got %t", test.download, !test.download)
+			}
+		})
+	}
+}
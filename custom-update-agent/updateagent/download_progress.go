@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// ProgressInterval is the minimum time between two download progress feedback events
+var ProgressInterval = 5 * time.Second
+
+// ProgressStep is the progress (in percent) of the whole download after which a feedback event is sent, regardless of ProgressInterval
+var ProgressStep = 10
+
+// progressTracker collects the download progress of all files in a baseline and sends throttled feedback events
+type progressTracker struct {
+	operation *operation
+	baseline  *action
+
+	lock          sync.Mutex
+	files         []*fileProgress
+	lastReport    time.Time
+	lastReportPct int
+}
+
+// fileProgress tracks the download progress of a single file, it is used as a writer for the received content
+type fileProgress struct {
+	tracker  *progressTracker
+	action   *fileAction
+	received int64
+	total    int64
+}
+
+// newProgressTracker returns the progress tracker of the given baseline.
+// All files to be downloaded are registered with their expected size up front,
+// so that the progress of the baseline does not reach 100% before the last file is downloaded.
+func newProgressTracker(o *operation, baseline *action) *progressTracker {
+	tracker := &progressTracker{
+		operation:  o,
+		baseline:   baseline,
+		lastReport: time.Now(),
+	}
+	for _, action := range baseline.actions {
+		if action.needsDownload() {
+			tracker.files = append(tracker.files, &fileProgress{tracker: tracker, action: action, total: action.expectedSize()})
+		}
+	}
+	return tracker
+}
+
+// expectedSize returns the size of the content to be downloaded for the action, -1 if unknown
+func (a *fileAction) expectedSize() int64 {
+	if a.size > 0 {
+		return a.size
+	}
+	if a.desired.Size > 0 {
+		return a.desired.Size
+	}
+	return -1
+}
+
+// forAction returns the progress of the given file action
+func (t *progressTracker) forAction(action *fileAction) *fileProgress {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, progress := range t.files {
+		if progress.action == action {
+			return progress
+		}
+	}
+	progress := &fileProgress{tracker: t, action: action, total: -1}
+	t.files = append(t.files, progress)
+	return progress
+}
+
+// begin resets the progress of the file when a download attempt starts from the given offset.
+// The expected size of the file is kept if the total size is not known from the response.
+func (p *fileProgress) begin(received int64, total int64) {
+	p.tracker.lock.Lock()
+	p.received = received
+	if total >= 0 {
+		p.total = total
+	}
+	p.tracker.lock.Unlock()
+
+	p.tracker.update(p)
+}
+
+// done marks the file as completely downloaded
+func (p *fileProgress) done() {
+	p.tracker.lock.Lock()
+	if p.total < 0 {
+		p.total = p.received
+	}
+	p.received = p.total
+	p.tracker.lock.Unlock()
+
+	p.tracker.update(p)
+}
+
+func (p *fileProgress) Write(data []byte) (int, error) {
+	p.tracker.lock.Lock()
+	p.received += int64(len(data))
+	p.tracker.lock.Unlock()
+
+	p.tracker.update(p)
+	return len(data), nil
+}
+
+// update refreshes the progress of the given file feedback action
+// and reports the progress of the whole baseline if the interval has elapsed or the progress step is reached
+func (t *progressTracker) update(progress *fileProgress) {
+	t.lock.Lock()
+	filePct := percent(progress.received, progress.total)
+	fileMessage := fmt.Sprintf("Downloading: %s", formatProgress(progress.received, progress.total))
+
+	var received, total int64
+	for _, file := range t.files {
+		received += file.received
+		if file.total > 0 {
+			total += file.total
+		}
+	}
+	baselinePct := percent(received, total)
+	report := time.Since(t.lastReport) >= ProgressInterval || baselinePct-t.lastReportPct >= ProgressStep
+	if report {
+		t.lastReport = time.Now()
+		t.lastReportPct = baselinePct
+	}
+	t.lock.Unlock()
+
+	o := t.operation
+	o.feedbackLock.Lock()
+	if progress.action.feedbackAction.Status == types.ActionStatusDownloading {
+		progress.action.feedbackAction.Progress = uint8(filePct)
+		progress.action.feedbackAction.Message = fileMessage
+	}
+	o.feedbackLock.Unlock()
+
+	if report {
+		o.Feedback(types.BaselineStatusDownloading, fmt.Sprintf("Downloaded %s", formatProgress(received, total)), "")
+	}
+}
+
+func percent(received int64, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	if received >= total {
+		return 100
+	}
+	return int(received * 100 / total)
+}
+
+func formatProgress(received int64, total int64) string {
+	if total <= 0 {
+		return formatBytes(received)
+	}
+	return fmt.Sprintf("%s of %s (%d%%)", formatBytes(received), formatBytes(total), percent(received, total))
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
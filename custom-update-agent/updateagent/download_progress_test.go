@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestProgressTracker(t *testing.T) {
+	interval := ProgressInterval
+	ProgressInterval = 0
+	defer func() { ProgressInterval = interval }()
+
+	newAction := func(name string, actionType util.ActionType, size int64, declared int64) *fileAction {
+		return &fileAction{
+			desired:        &util.File{Name: name, Size: declared},
+			feedbackAction: &types.Action{Status: types.ActionStatusDownloading},
+			actionType:     actionType,
+			size:           size,
+		}
+	}
+	tests := []struct {
+		name    string
+		actions []*fileAction
+		message string
+	}{
+		{
+			name:    "sizes determined during identification",
+			actions: []*fileAction{newAction("a", util.ActionAdd, 100, 0), newAction("b", util.ActionReplace, 300, 0)},
+			message: "Downloaded 100 B of 400 B (25%)",
+		},
+		{
+			name:    "declared sizes",
+			actions: []*fileAction{newAction("a", util.ActionAdd, 0, 100), newAction("b", util.ActionRepair, 0, 100)},
+			message: "Downloaded 100 B of 200 B (50%)",
+		},
+		{
+			name:    "files not downloaded",
+			actions: []*fileAction{newAction("a", util.ActionAdd, 100, 0), newAction("b", util.ActionNone, 300, 0), newAction("c", util.ActionRename, 300, 0)},
+			message: "Downloaded 100 B of 100 B (100%)",
+		},
+		{
+			name:    "unknown size",
+			actions: []*fileAction{newAction("a", util.ActionAdd, 100, 0), newAction("b", util.ActionAdd, 0, 0)},
+			message: "Downloaded 100 B of 100 B (100%)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updMgr, callback := newTestUpdateManager(t)
+			baseline := &action{status: types.BaselineStatusDownloading, actions: test.actions}
+			o := &operation{updateManager: updMgr, allActions: baseline}
+
+			progress := newProgressTracker(o, baseline).forAction(test.actions[0])
+			progress.begin(0, -1)
+			progress.Write(make([]byte, 100))
+			progress.done()
+
+			if message := callback.last().message; message != test.message {
+				t.Errorf("expected message %q, got %q", test.message, message)
+			}
+		})
+	}
+}
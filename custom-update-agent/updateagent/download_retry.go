@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// RetryPolicy defines how failed downloads are retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of download attempts per file, including the first one
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry, it is doubled for each next retry
+	InitialDelay time.Duration
+	// MaxDelay is the upper limit of the delay between two attempts, 0 means no limit
+	MaxDelay time.Duration
+	// Jitter is the fraction (between 0 and 1) by which each delay is randomly increased or decreased
+	Jitter float64
+	// MaxRetryAfter is the longest delay requested by a server with the Retry-After header that is waited for, 0 means no limit.
+	// The download fails right away if a server requests a longer delay.
+	MaxRetryAfter time.Duration
+}
+
+// DownloadRetry is the retry policy applied to failed downloads
+var DownloadRetry = RetryPolicy{
+	MaxAttempts:   3,
+	InitialDelay:  time.Second,
+	MaxDelay:      30 * time.Second,
+	Jitter:        0.2,
+	MaxRetryAfter: 10 * time.Minute,
+}
+
+// httpStatusError is returned when an unexpected HTTP status is received while downloading a file
+type httpStatusError struct {
+	url        string
+	statusCode int
+	retryAfter time.Duration
+}
+
+func newHTTPStatusError(resp *http.Response) *httpStatusError {
+	return &httpStatusError{
+		url:        resp.Request.URL.Redacted(),
+		statusCode: resp.StatusCode,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d %s received from [%s]", e.statusCode, http.StatusText(e.statusCode), e.url)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// isRetryable checks if the download failed due to a transient error and returns the delay requested by the server, if any.
+// Timeouts, connection resets, server errors and rate limiting are retried, any other failure is final.
+func isRetryable(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return false, 0
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.statusCode >= 500 || statusErr.statusCode == http.StatusTooManyRequests {
+			return true, statusErr.retryAfter
+		}
+		return false, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+	return false, 0
+}
+
+// delay returns the time to wait after the given failed attempt.
+// The delay requested by the server takes precedence over the backoff, even if it exceeds the maximum delay.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+	if p.Jitter > 0 {
+		jittered := float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1))
+		if jittered >= math.MaxInt64 {
+			delay = math.MaxInt64
+		} else {
+			delay = time.Duration(jittered)
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// downloadFileWithRetry downloads the file of the given action, retrying on transient errors according to the retry policy.
+// Files with the same content as an installed or a cached file are copied from them instead of being downloaded.
+// If the file has a binary patch matching the installed file, the file is rebuilt from the patch instead of being downloaded as a whole.
+// The downloaded file is added to the download cache.
+func (o *operation) downloadFileWithRetry(ctx context.Context, baselineAction *action, action *fileAction, progress *fileProgress) error {
+	if o.copyLocalFile(baselineAction, action, progress) {
+		return nil
+	}
+	if !o.downloadPatch(ctx, baselineAction, action, progress) {
+		err := o.retryDownload(ctx, baselineAction, action, func() error {
+			return o.downloadFile(ctx, action.desired, progress)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	addToCache(action.desired, filepath.Join(o.downloadDirectory, action.desired.Name))
+	return nil
+}
+
+// retryDownload runs the given download for the file of the given action, retrying on transient errors according to the retry policy.
+// Each retry is reported in the action feedback message.
+// Outside of the download windows, errDownloadWindowClosed is returned without retrying, so that the download is resumed when the next window opens.
+func (o *operation) retryDownload(ctx context.Context, baselineAction *action, action *fileAction, download func() error) error {
+	policy := DownloadRetry
+	for attempt := 1; ; attempt++ {
+		if !o.throttle.inDownloadWindow(time.Now()) {
+			return errDownloadWindowClosed
+		}
+		err := download()
+		if err == nil || errors.Is(err, errDownloadWindowClosed) {
+			return err
+		}
+		retryable, retryAfter := isRetryable(err)
+		if !retryable || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if policy.MaxRetryAfter > 0 && retryAfter > policy.MaxRetryAfter {
+			return fmt.Errorf("%w, the server requested to retry after %s, which exceeds the maximum of %s", err, retryAfter, policy.MaxRetryAfter)
+		}
+
+		delay := policy.delay(attempt, retryAfter)
+		message := fmt.Sprintf("Download attempt %d of %d failed: %v. Retrying in %s.", attempt, policy.MaxAttempts, err, delay.Round(time.Millisecond))
+		slog.Warn(fmt.Sprintf("[%s] %s", action.desired.Name, message))
+		o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloading, message)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
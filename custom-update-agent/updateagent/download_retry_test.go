@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		attempt    int
+		retryAfter time.Duration
+		delay      time.Duration
+	}{
+		{name: "first retry", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, attempt: 1, delay: time.Second},
+		{name: "doubled", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, attempt: 3, delay: 4 * time.Second},
+		{name: "limited", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, attempt: 4, delay: 5 * time.Second},
+		{name: "no limit", policy: RetryPolicy{InitialDelay: time.Second}, attempt: 5, delay: 16 * time.Second},
+		{name: "no limit without overflow", policy: RetryPolicy{InitialDelay: time.Second}, attempt: 100, delay: math.MaxInt64},
+		{name: "no limit with jitter without overflow", policy: RetryPolicy{InitialDelay: time.Second, Jitter: 0.5}, attempt: 100, delay: -1},
+		{name: "retry after", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, attempt: 1, retryAfter: 10 * time.Second, delay: 10 * time.Second},
+		{name: "retry after above the maximum delay", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, attempt: 1, retryAfter: 2 * time.Minute, delay: 2 * time.Minute},
+		{name: "backoff above retry after", policy: RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute}, attempt: 5, retryAfter: 2 * time.Second, delay: 16 * time.Second},
+		{name: "retry after without limit", policy: RetryPolicy{InitialDelay: time.Second}, attempt: 1, retryAfter: time.Hour, delay: time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay := test.policy.delay(test.attempt, test.retryAfter)
+			if test.delay < 0 {
+				// randomized by the jitter
+				if delay < time.Hour {
+					t.Errorf("expected a large positive delay, got %s", delay)
+				}
+				return
+			}
+			if delay != test.delay {
+				t.Errorf("expected delay %s, got %s", test.delay, delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelayJitter(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(2, 0); delay < 1600*time.Millisecond || delay > 2400*time.Millisecond {
+			t.Fatalf("expected delay between 1.6s and 2.4s, got %s", delay)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{name: "server error", err: &httpStatusError{statusCode: http.StatusBadGateway}, retryable: true},
+		{name: "rate limited", err: &httpStatusError{statusCode: http.StatusTooManyRequests, retryAfter: time.Minute}, retryable: true, retryAfter: time.Minute},
+		{name: "not found", err: &httpStatusError{statusCode: http.StatusNotFound}},
+		{name: "wrapped server error", err: fmt.Errorf("download failed: %w", &httpStatusError{statusCode: http.StatusServiceUnavailable}), retryable: true},
+		{name: "timeout", err: timeoutError{}, retryable: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), retryable: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), retryable: true},
+		{name: "truncated content", err: io.ErrUnexpectedEOF, retryable: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "checksum mismatch", err: errors.New("checksum mismatch")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retryable, retryAfter := isRetryable(test.err)
+			if retryable != test.retryable || retryAfter != test.retryAfter {
+				t.Errorf("expected %v and %s, got %v and %s", test.retryable, test.retryAfter, retryable, retryAfter)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{value: "", min: 0, max: 0},
+		{value: "120", min: 2 * time.Minute, max: 2 * time.Minute},
+		{value: "-1", min: 0, max: 0},
+		{value: "soon", min: 0, max: 0},
+		{value: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), min: 58 * time.Minute, max: time.Hour},
+		{value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), min: 0, max: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			if delay := parseRetryAfter(test.value); delay < test.min || delay > test.max {
+				t.Errorf("expected delay between %s and %s, got %s", test.min, test.max, delay)
+			}
+		})
+	}
+}
+
+func TestDownloadRetryAfter(t *testing.T) {
+	retry := DownloadRetry
+	defer func() { DownloadRetry = retry }()
+
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		status  types.StatusType
+		minimum time.Duration
+		message string
+	}{
+		{
+			name:    "retry after above the maximum delay",
+			policy:  RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, MaxRetryAfter: time.Minute},
+			status:  types.BaselineStatusDownloadSuccess,
+			minimum: time.Second,
+		},
+		{
+			name:    "retry after above the limit",
+			policy:  RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, MaxRetryAfter: 500 * time.Millisecond},
+			status:  types.BaselineStatusDownloadFailure,
+			message: "exceeds the maximum of 500ms",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			DownloadRetry = test.policy
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				// the size of the file is requested with a HEAD request during identification
+				if request.Method == http.MethodGet && attempts.Add(1) == 1 {
+					writer.Header().Set("Retry-After", "1")
+					writer.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				writer.Write([]byte(testContent))
+			}))
+			t.Cleanup(server.Close)
+			updMgr, callback := newTestUpdateManager(t)
+
+			updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin"}))
+			start := time.Now()
+			updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+			if !hasStatus(callback.statuses(), test.status) {
+				t.Fatalf("expected status %s, got %v", test.status, callback.statuses())
+			}
+			if elapsed := time.Since(start); elapsed < test.minimum {
+				t.Errorf("expected the download to wait at least %s, took %s", test.minimum, elapsed)
+			}
+			if message := callback.last().actions[0].Message; !strings.Contains(message, test.message) {
+				t.Errorf("expected action message containing %q, got %q", test.message, message)
+			}
+		})
+	}
+}
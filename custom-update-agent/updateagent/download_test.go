@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestDownloadResumedAfterInterruption(t *testing.T) {
+	retry := DownloadRetry
+	DownloadRetry = RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+	defer func() { DownloadRetry = retry }()
+
+	content := strings.Repeat("0123456789", 10000)
+	var lock sync.Mutex
+	var ranges []string
+	updMgr, callback := newTestUpdateManager(t)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("ETag", `"v1"`)
+		if request.Method != http.MethodGet {
+			http.ServeContent(writer, request, "a.bin", time.Time{}, strings.NewReader(content))
+			return
+		}
+		lock.Lock()
+		ranges = append(ranges, request.Header.Get("Range"))
+		first := len(ranges) == 1
+		lock.Unlock()
+		if first {
+			// the connection is closed after half of the content
+			writer.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			writer.Write([]byte(content[:len(content)/2]))
+			writer.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(writer, request, "a.bin", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin"}))
+	updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+	if status := callback.last().status; status != types.BaselineStatusDownloadSuccess {
+		t.Fatalf("expected status %s, got %v", types.BaselineStatusDownloadSuccess, callback.statuses())
+	}
+	data, err := os.ReadFile(filepath.Join(updMgr.directory.operationDirectory("activity"), "file_agent_download", "a.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("expected the downloaded content to match, got %d bytes", len(data))
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if last := ranges[len(ranges)-1]; last != fmt.Sprintf("bytes=%d-", len(content)/2) {
+		t.Errorf("expected the download to be resumed from the written bytes, got ranges %q", ranges)
+	}
+}
+
+func TestDownloadFailures(t *testing.T) {
+	retry, maxFileSize := DownloadRetry, MaxFileSize
+	DownloadRetry = RetryPolicy{MaxAttempts: 1}
+	defer func() { DownloadRetry, MaxFileSize = retry, maxFileSize }()
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		config      []*types.KeyValuePair
+		maxFileSize int64
+		message     string
+	}{
+		{
+			name:    "not found",
+			handler: func(writer http.ResponseWriter, _ *http.Request) { http.NotFound(writer, nil) },
+			message: "unexpected HTTP status 404",
+		},
+		{
+			name:    "server error",
+			handler: func(writer http.ResponseWriter, _ *http.Request) { writer.WriteHeader(http.StatusInternalServerError) },
+			message: "unexpected HTTP status 500",
+		},
+		{
+			name:    "no content",
+			handler: func(writer http.ResponseWriter, _ *http.Request) { writer.WriteHeader(http.StatusNoContent) },
+			message: "unexpected HTTP status 204",
+		},
+		{
+			name: "truncated content",
+			handler: func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Length", "100")
+				if request.Method == http.MethodGet {
+					writer.Write([]byte("only a part"))
+					writer.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+			},
+			message: "unexpected EOF",
+		},
+		{
+			name:    "declared size differs from the announced one",
+			handler: func(writer http.ResponseWriter, _ *http.Request) { writer.Write([]byte("content")) },
+			config:  []*types.KeyValuePair{{Key: "size", Value: "100"}},
+			message: "size mismatch",
+		},
+		{
+			name: "more content than declared",
+			handler: func(writer http.ResponseWriter, _ *http.Request) {
+				// the content length is not announced for chunked responses
+				writer.Write([]byte("content"))
+				writer.(http.Flusher).Flush()
+				writer.Write([]byte(" and more"))
+			},
+			config:  []*types.KeyValuePair{{Key: "size", Value: "7"}},
+			message: "received more than the expected 7 bytes",
+		},
+		{
+			name:        "exceeds the maximum file size",
+			handler:     func(writer http.ResponseWriter, _ *http.Request) { writer.Write([]byte("content")) },
+			maxFileSize: 5,
+			message:     "exceeds the maximum file size",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			MaxFileSize = test.maxFileSize
+			server := httptest.NewServer(test.handler)
+			t.Cleanup(server.Close)
+			updMgr, callback := newTestUpdateManager(t)
+
+			desiredState := newTestDesiredState(map[string]string{"a.bin": server.URL + "/a.bin"})
+			updMgr.Apply(context.Background(), "activity", withComponentConfig(desiredState, "a.bin", test.config...))
+			updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+			if !hasStatus(callback.statuses(), types.BaselineStatusDownloadFailure) {
+				t.Fatalf("expected status %s, got %v", types.BaselineStatusDownloadFailure, callback.statuses())
+			}
+			action := callback.last().actions[0]
+			if action.Status != types.ActionStatusDownloadFailure || !strings.Contains(action.Message, test.message) {
+				t.Errorf("expected action failure containing %q, got %s %q", test.message, action.Status, action.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// BandwidthLimit is the maximum download rate in bytes per second, shared by all downloads of the agent, 0 means no limit.
+// It can be overridden per desired state with the bandwidth_limit domain configuration.
+var BandwidthLimit int64
+
+// DownloadWindows are the local times of the day when files can be downloaded, e.g. "01:00-05:00,22:00-23:30", empty means at any time.
+// It can be overridden per desired state with the download_windows domain configuration.
+var DownloadWindows string
+
+// actionStatusWaitingForWindow is reported while a download is paused outside of the download windows
+const actionStatusWaitingForWindow types.ActionStatusType = "WAITING_FOR_WINDOW"
+
+// minBurstSize is the minimum number of bytes read at once from a throttled download
+const minBurstSize = 1024
+
+// errDownloadWindowClosed is returned when a file is to be downloaded outside of the download windows, or when a window closes while it is being downloaded.
+// The partially downloaded file is kept, so that the download is resumed when the next window opens.
+var errDownloadWindowClosed = errors.New("download window closed")
+
+var agentLimiter struct {
+	once    sync.Once
+	limiter *rateLimiter
+}
+
+// sharedRateLimiter returns the rate limiter shared by all downloads of the agent, nil if BandwidthLimit is not set
+func sharedRateLimiter() *rateLimiter {
+	agentLimiter.once.Do(func() {
+		if BandwidthLimit > 0 {
+			agentLimiter.limiter = newRateLimiter(BandwidthLimit)
+		}
+	})
+	return agentLimiter.limiter
+}
+
+// rateLimiter is a token bucket, which allows bursts of up to one second of the configured rate
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	burst := int(bytesPerSecond)
+	if burst < minBurstSize {
+		burst = minBurstSize
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes the given number of bytes from the bucket and blocks until they are covered by the rate or the context is done.
+// The bucket can go below zero, so that concurrent downloads wait in turn for the bytes they have already read.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens = math.Min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// downloadWindow is a time range of the day in minutes, it ends on the next day if end is before start
+type downloadWindow struct {
+	start int
+	end   int
+}
+
+// downloadWindows are the time ranges of the day when downloads are allowed, empty means at any time
+type downloadWindows []downloadWindow
+
+// parseDownloadWindows parses comma separated time ranges in the form HH:MM-HH:MM
+func parseDownloadWindows(value string) (downloadWindows, error) {
+	windows := downloadWindows{}
+	if strings.TrimSpace(value) == "" {
+		return windows, nil
+	}
+	for _, window := range strings.Split(value, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(window), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid download window %s, expected HH:MM-HH:MM", window)
+		}
+		startMinute, err := parseTimeOfDay(start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of download window %s: %w", window, err)
+		}
+		endMinute, err := parseTimeOfDay(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end of download window %s: %w", window, err)
+		}
+		if startMinute == endMinute {
+			return nil, fmt.Errorf("download window %s is empty", window)
+		}
+		windows = append(windows, downloadWindow{start: startMinute, end: endMinute})
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func (w downloadWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+func (w downloadWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60)
+}
+
+// contains checks if downloads are allowed at the given time
+func (windows downloadWindows) contains(t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range windows {
+		if window.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the start of the first download window after the given time
+func (windows downloadWindows) next(t time.Time) time.Time {
+	var next time.Time
+	for _, window := range windows {
+		start := time.Date(t.Year(), t.Month(), t.Day(), window.start/60, window.start%60, 0, 0, t.Location())
+		if !start.After(t) {
+			start = time.Date(t.Year(), t.Month(), t.Day()+1, window.start/60, window.start%60, 0, 0, t.Location())
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+func (windows downloadWindows) String() string {
+	ranges := make([]string, len(windows))
+	for i, window := range windows {
+		ranges[i] = window.String()
+	}
+	return strings.Join(ranges, ",")
+}
+
+// downloadThrottle holds the bandwidth limit and the download windows applied to the downloads of an operation
+type downloadThrottle struct {
+	limiter *rateLimiter
+	windows downloadWindows
+}
+
+// newDownloadThrottle returns the throttle of the operation downloads, the desired state settings take precedence over the agent ones
+func (o *operation) newDownloadThrottle() (*downloadThrottle, error) {
+	throttle := &downloadThrottle{limiter: sharedRateLimiter()}
+	if o.desiredState.bandwidthLimit > 0 {
+		throttle.limiter = newRateLimiter(o.desiredState.bandwidthLimit)
+	}
+	windows := DownloadWindows
+	if o.desiredState.downloadWindows != "" {
+		windows = o.desiredState.downloadWindows
+	}
+	var err error
+	throttle.windows, err = parseDownloadWindows(windows)
+	return throttle, err
+}
+
+// reader returns the given reader throttled to the bandwidth limit, which fails once the download window closes
+func (t *downloadThrottle) reader(ctx context.Context, reader io.Reader) io.Reader {
+	if t == nil || (t.limiter == nil && len(t.windows) == 0) {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, reader: reader, throttle: t}
+}
+
+type throttledReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	throttle *downloadThrottle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if !r.throttle.windows.contains(time.Now()) {
+		return 0, errDownloadWindowClosed
+	}
+	limiter := r.throttle.limiter
+	if limiter != nil && len(p) > limiter.burst {
+		p = p[:limiter.burst]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 && limiter != nil {
+		if waitErr := limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// inDownloadWindow checks if downloads are allowed at the given time
+func (t *downloadThrottle) inDownloadWindow(now time.Time) bool {
+	return t == nil || t.windows.contains(now)
+}
+
+// pauseDownload reports the action of a file, which cannot be downloaded outside of the download windows, with status WAITING_FOR_WINDOW
+func (o *operation) pauseDownload(baselineAction *action, action *fileAction) {
+	windows := o.throttle.windows
+	message := fmt.Sprintf("Waiting for download window %s, the download continues at %s.", windows, windows.next(time.Now()).Format("15:04"))
+	slog.Info(fmt.Sprintf("[%s] %s", action.desired.Name, message))
+	o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, actionStatusWaitingForWindow, message)
+}
+
+// scheduleDownload resumes the download of the given operation at the given time, when the next download window opens.
+// The apply lock is not held while waiting, so that other desired states and commands are processed meanwhile.
+func (updMgr *fileUpdateManager) scheduleDownload(o *operation, at time.Time) {
+	updMgr.stopScheduledDownload()
+	updMgr.downloadTimer = time.AfterFunc(time.Until(at), func() {
+		updMgr.resumeDownload(o)
+	})
+}
+
+func (updMgr *fileUpdateManager) stopScheduledDownload() {
+	if updMgr.downloadTimer != nil {
+		updMgr.downloadTimer.Stop()
+		updMgr.downloadTimer = nil
+	}
+}
+
+// resumeDownload continues the paused download of the given operation, unless it has been replaced or cleaned up meanwhile
+func (updMgr *fileUpdateManager) resumeDownload(o *operation) {
+	updMgr.applyLock.Lock()
+	defer updMgr.applyLock.Unlock()
+
+	if updMgr.ctx.Err() != nil || updMgr.operation != UpdateOperation(o) || !updMgr.operationInProgress.Load() {
+		return
+	}
+	updMgr.downloadTimer = nil
+	ctx, cancel := updMgr.operationContext(context.Background())
+	defer cancel()
+	o.resumeDownload(ctx)
+}
+
+// resumeDownload continues the download paused outside of the download windows.
+// The files downloaded before the pause are not downloaded again and the interrupted downloads are resumed from their last written byte.
+func (o *operation) resumeDownload(ctx context.Context) {
+	if o.allActions == nil || o.allActions.status != types.BaselineStatusDownloading {
+		return
+	}
+	slog.Info(fmt.Sprintf("download window opened, resuming the download of activityId %s", o.activityID))
+	o.paused = false
+	o.saveJournal(types.BaselineStatusDownloading)
+	download(ctx, o, o.allActions)
+	o.saveJournal(o.allActions.status)
+}
+
+// schedulePausedDownload resumes the download paused before an agent restart when the next download window opens, or right away if a window is open.
+// It returns false if the download windows of the operation are invalid.
+func (o *operation) schedulePausedDownload() bool {
+	throttle, err := o.newDownloadThrottle()
+	if err != nil {
+		slog.Error("invalid download throttling", "error", err)
+		return false
+	}
+	o.throttle = throttle
+	at := time.Now()
+	if !throttle.inDownloadWindow(at) {
+		at = throttle.windows.next(at)
+	}
+	slog.Info(fmt.Sprintf("download of activityId %s paused until %s", o.activityID, at.Format("15:04")))
+	o.updateManager.scheduleDownload(o, at)
+	return true
+}
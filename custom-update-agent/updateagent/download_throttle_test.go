@@ -0,0 +1,230 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestParseDownloadWindows(t *testing.T) {
+	tests := []struct {
+		value   string
+		windows downloadWindows
+		err     bool
+	}{
+		{value: "", windows: downloadWindows{}},
+		{value: "01:00-05:00", windows: downloadWindows{{start: 60, end: 300}}},
+		{value: " 22:00-23:30 , 23:00-04:00", windows: downloadWindows{{start: 1320, end: 1410}, {start: 1380, end: 240}}},
+		{value: "01:00", err: true},
+		{value: "01:00-25:00", err: true},
+		{value: "1am-5am", err: true},
+		{value: "02:00-02:00", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			windows, err := parseDownloadWindows(test.value)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error, got windows %v", windows)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if windows.String() != test.windows.String() || len(windows) != len(test.windows) {
+				t.Errorf("expected windows %v, got %v", test.windows, windows)
+			}
+		})
+	}
+}
+
+func TestDownloadWindowsContainsAndNext(t *testing.T) {
+	day := func(hour int, minute int) time.Time {
+		return time.Date(2024, 3, 10, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		windows  string
+		now      time.Time
+		contains bool
+		next     time.Time
+	}{
+		{windows: "01:00-05:00", now: day(3, 0), contains: true, next: day(25, 0)},
+		{windows: "01:00-05:00", now: day(0, 59), contains: false, next: day(1, 0)},
+		{windows: "01:00-05:00", now: day(5, 0), contains: false, next: day(25, 0)},
+		{windows: "23:00-04:00", now: day(23, 30), contains: true, next: day(47, 0)},
+		{windows: "23:00-04:00", now: day(2, 0), contains: true, next: day(23, 0)},
+		{windows: "23:00-04:00", now: day(12, 0), contains: false, next: day(23, 0)},
+		{windows: "01:00-02:00,13:00-14:00", now: day(12, 0), contains: false, next: day(13, 0)},
+		{windows: "01:00-02:00,13:00-14:00", now: day(15, 0), contains: false, next: day(25, 0)},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s at %s", test.windows, test.now.Format("15:04")), func(t *testing.T) {
+			windows, err := parseDownloadWindows(test.windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contains := windows.contains(test.now); contains != test.contains {
+				t.Errorf("expected contains %v, got %v", test.contains, contains)
+			}
+			if next := windows.next(test.now); !next.Equal(test.next) {
+				t.Errorf("expected next window at %s, got %s", test.next, next)
+			}
+		})
+	}
+}
+
+func TestDownloadPausedOutsideOfWindows(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a"})
+
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	desiredState := newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}, &types.KeyValuePair{Key: domainConfigDownloadWindows, Value: closed})
+	updMgr.Apply(context.Background(), "activity", desiredState)
+	updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+	last := callback.last()
+	if last.status != types.BaselineStatusDownloading || len(last.actions) != 1 || last.actions[0].Status != actionStatusWaitingForWindow {
+		t.Fatalf("expected baseline %s with action %s, got %+v", types.BaselineStatusDownloading, actionStatusWaitingForWindow, last)
+	}
+	updMgr.applyLock.Lock()
+	scheduled := updMgr.downloadTimer != nil
+	updMgr.applyLock.Unlock()
+	if !scheduled {
+		t.Fatal("expected the download to be resumed when the window opens")
+	}
+
+	// the download is resumed as if the window opened
+	o := updMgr.operation.(*operation)
+	o.desiredState.downloadWindows = ""
+	updMgr.resumeDownload(o)
+	if status := callback.last().status; status != types.BaselineStatusDownloadSuccess {
+		t.Fatalf("expected status %s after resuming, got %v", types.BaselineStatusDownloadSuccess, callback.statuses())
+	}
+}
+
+func TestPausedDownloadRecovered(t *testing.T) {
+	updMgr, _ := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a"})
+
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	updMgr.Apply(context.Background(), "paused", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"},
+		&types.KeyValuePair{Key: domainConfigDownloadWindows, Value: closed}))
+	updMgr.Command(context.Background(), "paused", &types.DesiredStateCommand{Command: types.CommandDownload})
+	j, err := updMgr.directory.loadJournal()
+	if err != nil || j == nil || !j.Paused {
+		t.Fatalf("expected a journal of the paused download, got %+v (%v)", j, err)
+	}
+
+	restarted, callback := restartTestUpdateManager(t, updMgr)
+	restarted.recoverOperation()
+	last := callback.last()
+	if last.status != types.BaselineStatusDownloading || len(last.actions) != 1 || last.actions[0].Status != actionStatusWaitingForWindow {
+		t.Fatalf("expected baseline %s with action %s after the restart, got %v", types.BaselineStatusDownloading, actionStatusWaitingForWindow, callback.statuses())
+	}
+	restarted.applyLock.Lock()
+	scheduled := restarted.downloadTimer != nil
+	restarted.applyLock.Unlock()
+	if !scheduled {
+		t.Fatal("expected the recovered download to be resumed when the window opens")
+	}
+
+	// the download is resumed as if the window opened
+	o := restarted.operation.(*operation)
+	o.desiredState.downloadWindows = ""
+	restarted.resumeDownload(o)
+	if status := callback.last().status; status != types.BaselineStatusDownloadSuccess {
+		t.Fatalf("expected status %s after resuming, got %v", types.BaselineStatusDownloadSuccess, callback.statuses())
+	}
+	if j, err = restarted.directory.loadJournal(); err != nil || j == nil || j.Paused {
+		t.Errorf("expected a journal of the resumed download, got %+v (%v)", j, err)
+	}
+}
+
+func TestPausedDownloadReplacedByNewDesiredState(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a"})
+
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	updMgr.Apply(context.Background(), "paused", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"},
+		&types.KeyValuePair{Key: domainConfigDownloadWindows, Value: closed}))
+	updMgr.Command(context.Background(), "paused", &types.DesiredStateCommand{Command: types.CommandDownload})
+	paused := updMgr.operation.(*operation)
+
+	updMgr.Apply(context.Background(), "replacing", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}))
+	if updMgr.downloadTimer != nil {
+		t.Error("expected the resumption of the paused download to be stopped")
+	}
+	reported := len(callback.statuses())
+	paused.desiredState.downloadWindows = ""
+	updMgr.resumeDownload(paused)
+	if statuses := callback.statuses(); len(statuses) != reported {
+		t.Errorf("expected no feedback for the replaced operation, got %v", statuses[reported:])
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	closedWindows, err := parseDownloadWindows(closed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the first second of the rate is available as burst, the rest of the content is read at the limited rate
+	const rate = 20 * 1024
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		throttle *downloadThrottle
+		minimum  time.Duration
+		err      error
+	}{
+		{name: "not throttled", ctx: context.Background(), throttle: &downloadThrottle{}},
+		{name: "bandwidth limit", ctx: context.Background(), throttle: &downloadThrottle{limiter: newRateLimiter(rate)}, minimum: 900 * time.Millisecond},
+		{name: "cancelled", ctx: cancelled, throttle: &downloadThrottle{limiter: newRateLimiter(rate)}, err: context.Canceled},
+		{name: "closed window", ctx: context.Background(), throttle: &downloadThrottle{windows: closedWindows}, err: errDownloadWindowClosed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{'a'}, 2*rate)
+			start := time.Now()
+			read, err := io.ReadAll(test.throttle.reader(test.ctx, bytes.NewReader(content)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if test.err != nil {
+				return
+			}
+			if len(read) != len(content) {
+				t.Errorf("expected %d bytes, got %d", len(content), len(read))
+			}
+			if elapsed := time.Since(start); elapsed < test.minimum {
+				t.Errorf("expected the download to take at least %s, took %s", test.minimum, elapsed)
+			}
+		})
+	}
+}
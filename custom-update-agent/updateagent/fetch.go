@@ -0,0 +1,221 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Fetcher retrieves the content of artifacts addressed by URLs with a given scheme
+type Fetcher interface {
+	// Fetch opens the content of the requested artifact. If the request has a positive offset and the content still matches the request validator,
+	// the returned body starts at that offset. Otherwise, the whole content is returned with zero offset.
+	Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error)
+}
+
+// SizeResolver is implemented by fetchers, which can determine the size of an artifact without opening its content
+type SizeResolver interface {
+	// Size returns the size of the requested artifact, -1 if it is unknown
+	Size(ctx context.Context, request *FetchRequest) (int64, error)
+}
+
+// FetchRequest defines the artifact to be fetched
+type FetchRequest struct {
+	// URL is the location of the artifact
+	URL *url.URL
+	// Name is the name of the file the artifact is downloaded as
+	Name string
+	// Offset is the number of bytes already downloaded
+	Offset int64
+	// Validator identifies the content version the already downloaded bytes belong to
+	Validator string
+	// HTTP holds the authentication, custom headers and TLS settings for HTTP based sources, nil for anonymous requests with the default settings
+	HTTP *HTTPConfig
+}
+
+// FetchResponse holds the fetched artifact content
+type FetchResponse struct {
+	// Body is the artifact content starting at Offset
+	Body io.ReadCloser
+	// Offset is the position of the first byte of Body in the whole content
+	Offset int64
+	// Size is the size of the whole content, -1 if unknown
+	Size int64
+	// Validator identifies the content version, it is empty if the download cannot be resumed
+	Validator string
+	// Digest is the digest of the whole content in the form <algorithm>:<hex>, if known by the source
+	Digest string
+	// Encoding is the content encoding announced by the source, e.g. gzip, empty if the content is not encoded
+	Encoding string
+}
+
+var (
+	fetchersLock sync.RWMutex
+	fetchers     = map[string]Fetcher{}
+)
+
+func init() {
+	httpFetcher := &httpFetcher{}
+	RegisterFetcher("http", httpFetcher)
+	RegisterFetcher("https", httpFetcher)
+}
+
+// RegisterFetcher registers the fetcher used for download URLs with the given scheme, replacing any fetcher registered before for it
+func RegisterFetcher(scheme string, fetcher Fetcher) {
+	fetchersLock.Lock()
+	defer fetchersLock.Unlock()
+
+	fetchers[strings.ToLower(scheme)] = fetcher
+}
+
+// fetcherFor returns the fetcher registered for the scheme of the given URL
+func fetcherFor(location *url.URL) (Fetcher, error) {
+	fetchersLock.RLock()
+	defer fetchersLock.RUnlock()
+
+	fetcher, ok := fetchers[strings.ToLower(location.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported download url scheme [%s]", location.Scheme)
+	}
+	return fetcher, nil
+}
+
+// httpFetcher fetches artifacts over HTTP(S), resuming downloads with range requests.
+// The HTTP settings of the requests are applied to the requests before they are sent.
+type httpFetcher struct {
+	// client is used for requests without TLS settings, the default client with the configured timeouts is used if not set
+	client *http.Client
+}
+
+// Fetch sends a GET request for the requested artifact
+func (f *httpFetcher) Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error) {
+	return f.get(ctx, request.URL.String(), request, nil)
+}
+
+// Size sends a HEAD request for the requested artifact and returns its announced content length.
+// The size is unknown if the server does not support HEAD requests.
+func (f *httpFetcher) Size(ctx context.Context, request *FetchRequest) (int64, error) {
+	return f.head(ctx, request.URL.String(), request, nil)
+}
+
+// head sends a HEAD request for the content at the given target and returns its announced content length, -1 if it is unknown.
+// The request can be modified (e.g. signed) before sending via the prepare function.
+func (f *httpFetcher) head(ctx context.Context, target string, request *FetchRequest, prepare func(*http.Request) error) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return -1, err
+	}
+	if err = request.HTTP.apply(req); err != nil {
+		return -1, err
+	}
+	if prepare != nil {
+		if err = prepare(req); err != nil {
+			return -1, err
+		}
+	}
+	resp, err := f.do(req, request.HTTP)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// get requests the content at the given target, the request can be modified (e.g. signed) before sending via the prepare function.
+// If the request has a positive offset, only the content after it is requested, as long as the remote content still matches the request validator (if any).
+// Responses other than 200 OK and 206 Partial Content are rejected.
+// The request is cancelled if no content is received within the idle read timeout while its body is read.
+func (f *httpFetcher) get(ctx context.Context, target string, request *FetchRequest, prepare func(*http.Request) error) (*FetchResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := f.send(ctx, target, request, prepare)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, request.URL.Redacted(), cancel)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, newHTTPStatusError(resp)
+	}
+
+	result := &FetchResponse{
+		Body:      resp.Body,
+		Size:      resp.ContentLength,
+		Validator: resumeValidator(resp),
+	}
+	if !resp.Uncompressed {
+		// content transparently decompressed by the client is not reported as encoded
+		result.Encoding = resp.Header.Get("Content-Encoding")
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		if !hasRangeStart(resp, request.Offset) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected content range [%s] received for file [%s]", resp.Header.Get("Content-Range"), request.Name)
+		}
+		result.Offset = request.Offset
+		if result.Size >= 0 {
+			result.Size += request.Offset
+		}
+	}
+	return result, nil
+}
+
+// send sends a GET request for the content at the given target
+func (f *httpFetcher) send(ctx context.Context, target string, request *FetchRequest, prepare func(*http.Request) error) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if request.Offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", request.Offset))
+		if request.Validator != "" {
+			req.Header.Set("If-Range", request.Validator)
+		}
+	}
+	if err = request.HTTP.apply(req); err != nil {
+		return nil, err
+	}
+	if prepare != nil {
+		if err = prepare(req); err != nil {
+			return nil, err
+		}
+	}
+	return f.do(req, request.HTTP)
+}
+
+// resumeValidator returns the value to be used in the If-Range header for the given response.
+// Weak entity tags cannot be used for range requests, so the last modification date is taken in that case.
+func resumeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// hasRangeStart checks if the Content-Range of a partial response starts at the given offset
+func hasRangeStart(resp *http.Response, offset int64) bool {
+	var start int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil {
+		return false
+	}
+	return start == offset
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	RegisterFetcher("data", &dataFetcher{})
+}
+
+// dataFetcher fetches tiny artifacts inlined in data: URIs (RFC 2397), e.g. data:;base64,aGVsbG8=
+type dataFetcher struct{}
+
+// Fetch decodes the content of a data: URI
+func (f *dataFetcher) Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error) {
+	data, err := decodeDataURI(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &FetchResponse{
+		Body: io.NopCloser(bytes.NewReader(data)),
+		Size: int64(len(data)),
+	}, nil
+}
+
+func decodeDataURI(location *url.URL) ([]byte, error) {
+	// the data URI content is opaque, as it does not start with a slash
+	uri := location.Opaque
+	if uri == "" {
+		uri = strings.TrimPrefix(location.String(), location.Scheme+":")
+	}
+	header, content, found := strings.Cut(uri, ",")
+	if !found {
+		return nil, errors.New("invalid data uri, missing comma separator")
+	}
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		content, err := url.PathUnescape(content)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(content)
+	}
+	decoded, err := url.PathUnescape(content)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
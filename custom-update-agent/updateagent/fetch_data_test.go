@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"testing"
+)
+
+func TestDataFetcher(t *testing.T) {
+	tests := []struct {
+		uri     string
+		content string
+		err     bool
+	}{
+		{uri: "data:;base64,aGVsbG8=", content: "hello"},
+		{uri: "data:text/plain;BASE64,aGVsbG8=", content: "hello"},
+		{uri: "data:,hello%20world", content: "hello world"},
+		{uri: "data:text/plain;charset=utf-8,hello", content: "hello"},
+		{uri: "data:,", content: ""},
+		{uri: "data:hello", err: true},
+		{uri: "data:;base64,not base64!", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *testing.T) {
+			location, err := url.Parse(test.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := (&dataFetcher{}).Fetch(context.Background(), &FetchRequest{URL: location})
+			if test.err {
+				if err == nil {
+					t.Error("expected the data uri to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(response.Body)
+			if string(content) != test.content || response.Size != int64(len(test.content)) {
+				t.Errorf("expected content %q, got %q of size %d", test.content, content, response.Size)
+			}
+		})
+	}
+}
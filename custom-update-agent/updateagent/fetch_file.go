@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSourceDirectories is the comma separated list of local directories that files can be fetched from with file:// URLs.
+// file:// URLs are rejected if it is not set.
+var FileSourceDirectories string
+
+func init() {
+	RegisterFetcher("file", &fileFetcher{})
+}
+
+// fileFetcher fetches artifacts from the local file system, e.g. from mounted network shares or USB sticks
+type fileFetcher struct{}
+
+// allowedSourcePath resolves the symbolic links of the given path and checks that it is inside one of the FileSourceDirectories
+func allowedSourcePath(path string) (string, error) {
+	if strings.TrimSpace(FileSourceDirectories) == "" {
+		return "", errors.New("file urls are not allowed, no source directories are configured")
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	for _, directory := range strings.Split(FileSourceDirectories, ",") {
+		directory = strings.TrimSpace(directory)
+		if directory == "" {
+			continue
+		}
+		resolvedDirectory, err := filepath.EvalSymlinks(directory)
+		if err != nil {
+			continue
+		}
+		if isInside(resolvedDirectory, resolved) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("[%s] is not inside any of the allowed source directories", path)
+}
+
+// Fetch opens the local file addressed by a file:// URL
+func (f *fileFetcher) Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error) {
+	if request.URL.Host != "" && request.URL.Host != "localhost" {
+		return nil, fmt.Errorf("unsupported host [%s] in file url, only local files can be fetched", request.URL.Host)
+	}
+	path, err := allowedSourcePath(request.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("[%s] is not a regular file", request.URL.Path)
+	}
+
+	response := &FetchResponse{
+		Body:      file,
+		Size:      info.Size(),
+		Validator: fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()),
+	}
+	if request.Offset > 0 && request.Offset <= info.Size() && request.Validator == response.Validator {
+		if _, err = file.Seek(request.Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		response.Offset = request.Offset
+	}
+	return response, nil
+}
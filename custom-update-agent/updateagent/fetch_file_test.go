@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileFetcherSourceDirectories(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "usb")
+	outside := filepath.Join(root, "secret")
+	for _, directory := range []string{allowed, outside} {
+		if err := os.Mkdir(directory, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{filepath.Join(allowed, "app.bin"): "app", filepath.Join(outside, "key"): "secret"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "key"), filepath.Join(allowed, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(allowed, filepath.Join(root, "media")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		directories string
+		path        string
+		err         string
+	}{
+		{name: "allowed file", directories: allowed, path: filepath.Join(allowed, "app.bin")},
+		{name: "one of several directories", directories: outside + ", " + allowed, path: filepath.Join(allowed, "app.bin")},
+		{name: "allowed directory through a symbolic link", directories: filepath.Join(root, "media"), path: filepath.Join(allowed, "app.bin")},
+		{name: "no directories", path: filepath.Join(allowed, "app.bin"), err: "not allowed"},
+		{name: "outside", directories: allowed, path: filepath.Join(outside, "key"), err: "not inside"},
+		{name: "parent reference", directories: allowed, path: allowed + "/../secret/key", err: "not inside"},
+		{name: "symbolic link to the outside", directories: allowed, path: filepath.Join(allowed, "link"), err: "not inside"},
+		{name: "prefix of another directory", directories: filepath.Join(root, "us"), path: filepath.Join(allowed, "app.bin"), err: "not inside"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directories := FileSourceDirectories
+			FileSourceDirectories = test.directories
+			defer func() { FileSourceDirectories = directories }()
+
+			response, err := (&fileFetcher{}).Fetch(context.Background(), &FetchRequest{URL: &url.URL{Scheme: "file", Path: filepath.ToSlash(test.path)}})
+			if test.err != "" {
+				if err == nil {
+					response.Body.Close()
+				}
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if content, err := io.ReadAll(response.Body); err != nil || string(content) != "app" {
+				t.Errorf("expected content %q, got %q (%v)", "app", content, err)
+			}
+		})
+	}
+}
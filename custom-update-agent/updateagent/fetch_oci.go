@@ -0,0 +1,250 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	ociTitleAnnotation  = "org.opencontainers.image.title"
+	ociManifestMaxBytes = 4 * 1024 * 1024
+)
+
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// OCIPlainHTTP enables plain HTTP instead of HTTPS when pulling artifacts from OCI registries, e.g. from local test registries
+var OCIPlainHTTP = false
+
+func init() {
+	RegisterFetcher("oci", &ociFetcher{http: &httpFetcher{}})
+}
+
+// ociFetcher pulls artifacts by digest from OCI registries via oci://<registry>/<repository>@<digest> URLs.
+// The digest can refer either to a blob or to an artifact manifest. For manifests, the layer with a title annotation matching the file name is pulled,
+// or the only layer if the manifest has a single one.
+type ociFetcher struct {
+	http *httpFetcher
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	Layers []*ociDescriptor `json:"layers"`
+}
+
+// Fetch resolves the blob to be pulled and downloads it, resuming from the requested offset if the same blob was partially downloaded before
+func (f *ociFetcher) Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error) {
+	base, authorize, err := f.connect(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := f.resolveBlob(ctx, base, request, authorize)
+	if err != nil {
+		return nil, err
+	}
+	blobRequest := &FetchRequest{URL: request.URL, Name: request.Name, HTTP: request.HTTP}
+	if request.Validator == blob.Digest {
+		// blobs are content addressed, a partially downloaded blob with the same digest can always be resumed
+		blobRequest.Offset = request.Offset
+	}
+	response, err := f.http.get(ctx, base.String()+"/blobs/"+blob.Digest, blobRequest, authorize)
+	if err != nil {
+		return nil, err
+	}
+	response.Validator = blob.Digest
+	response.Digest = blob.Digest
+	return response, nil
+}
+
+// Size returns the size of the blob to be pulled, as declared by the layer in the artifact manifest.
+// If the digest refers to the blob itself, the size is requested from the registry with a HEAD request.
+func (f *ociFetcher) Size(ctx context.Context, request *FetchRequest) (int64, error) {
+	base, authorize, err := f.connect(ctx, request)
+	if err != nil {
+		return -1, err
+	}
+	blob, err := f.resolveBlob(ctx, base, request, authorize)
+	if err != nil {
+		return -1, err
+	}
+	if blob.Size >= 0 {
+		return blob.Size, nil
+	}
+	return f.http.head(ctx, base.String()+"/blobs/"+blob.Digest, request, authorize)
+}
+
+// connect returns the base URL of the requested repository and a function authorizing the requests to it
+func (f *ociFetcher) connect(ctx context.Context, request *FetchRequest) (*url.URL, func(*http.Request) error, error) {
+	repository, _, found := strings.Cut(strings.TrimPrefix(request.URL.Path, "/"), "@")
+	if request.URL.Host == "" || repository == "" || !found {
+		return nil, nil, fmt.Errorf("invalid oci url [%s], expected oci://<registry>/<repository>@<digest>", request.URL.Redacted())
+	}
+	scheme := "https"
+	if OCIPlainHTTP {
+		scheme = "http"
+	}
+	base := &url.URL{Scheme: scheme, Host: request.URL.Host, Path: "/v2/" + repository}
+
+	token, err := f.authorize(ctx, base, repository, request.HTTP)
+	if err != nil {
+		return nil, nil, err
+	}
+	return base, func(req *http.Request) error {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return nil
+	}, nil
+}
+
+// resolveBlob returns the descriptor of the blob to be pulled for the digest of the request.
+// The size of the blob is -1 if the digest refers to the blob itself instead of a manifest.
+func (f *ociFetcher) resolveBlob(ctx context.Context, base *url.URL, request *FetchRequest, authorize func(*http.Request) error) (*ociDescriptor, error) {
+	_, digest, _ := strings.Cut(request.URL.Path, "@")
+	name := request.Name
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.String()+"/manifests/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(ociManifestMediaTypes, ", "))
+	if err = request.HTTP.apply(req); err != nil {
+		return nil, err
+	}
+	authorize(req)
+	resp, err := f.http.do(req, request.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// not a manifest, the digest refers to the blob itself
+		return &ociDescriptor{Digest: digest, Size: -1}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHTTPStatusError(resp)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ociManifestMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); strings.HasPrefix(digest, "sha256:") && "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf("digest mismatch for manifest [%s]", digest)
+	}
+	manifest := &ociManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest [%s]: %w", digest, err)
+	}
+	for _, layer := range manifest.Layers {
+		if layer.Annotations[ociTitleAnnotation] == name {
+			return layer, nil
+		}
+	}
+	if len(manifest.Layers) == 1 {
+		return manifest.Layers[0], nil
+	}
+	return nil, fmt.Errorf("cannot select a layer for file [%s] in manifest [%s] with %d layers", name, digest, len(manifest.Layers))
+}
+
+// authorize obtains a pull token for the given repository, if the registry requires token authentication.
+// The token is requested with the credentials of the given HTTP configuration, if any, otherwise anonymously.
+func (f *ociFetcher) authorize(ctx context.Context, base *url.URL, repository string, config *HTTPConfig) (string, error) {
+	ping := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/v2/"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ping.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if err = config.apply(req); err != nil {
+		return "", err
+	}
+	resp, err := f.http.do(req, config)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", nil
+	}
+
+	challenge := parseBearerChallenge(resp.Header.Get("WWW-Authenticate"))
+	realm, err := url.Parse(challenge["realm"])
+	if err != nil || challenge["realm"] == "" {
+		return "", fmt.Errorf("unsupported authentication challenge received from registry [%s]", base.Host)
+	}
+	query := realm.Query()
+	if challenge["service"] != "" {
+		query.Set("service", challenge["service"])
+	}
+	query.Set("scope", "repository:"+repository+":pull")
+	realm.RawQuery = query.Encode()
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if err = config.apply(req); err != nil {
+		return "", err
+	}
+	resp, err = f.http.do(req, config)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newHTTPStatusError(resp)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseBearerChallenge parses the parameters of a WWW-Authenticate: Bearer realm="...",service="..." header
+func parseBearerChallenge(header string) map[string]string {
+	params := map[string]string{}
+	scheme, rest, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return params
+	}
+	for _, param := range strings.Split(rest, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if found {
+			params[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return params
+}
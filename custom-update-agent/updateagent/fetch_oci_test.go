@@ -0,0 +1,157 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRegistry serves a single layer artifact from the repository files, requiring the X-Api-Key header on all requests
+// and a token issued for basic credentials on the registry requests
+func newTestRegistry(t *testing.T, layer string) (*httptest.Server, string) {
+	t.Helper()
+	layerSum := sha256.Sum256([]byte(layer))
+	layerDigest := "sha256:" + hex.EncodeToString(layerSum[:])
+	manifest, _ := json.Marshal(&ociManifest{Layers: []*ociDescriptor{{
+		MediaType:   "application/octet-stream",
+		Digest:      layerDigest,
+		Size:        int64(len(layer)),
+		Annotations: map[string]string{ociTitleAnnotation: "app.bin"},
+	}}})
+	manifestSum := sha256.Sum256(manifest)
+	manifestDigest := "sha256:" + hex.EncodeToString(manifestSum[:])
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("X-Api-Key") != "key" {
+			http.Error(writer, "missing api key", http.StatusForbidden)
+			return
+		}
+		if request.URL.Path == "/token" {
+			if username, password, ok := request.BasicAuth(); !ok || username != "user" || password != "secret" {
+				http.Error(writer, "invalid credentials", http.StatusUnauthorized)
+				return
+			}
+			writer.Write([]byte(`{"token":"pull-token"}`))
+			return
+		}
+		if request.Header.Get("Authorization") != "Bearer pull-token" {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch request.URL.Path {
+		case "/v2/":
+		case "/v2/files/manifests/" + manifestDigest:
+			writer.Write(manifest)
+		case "/v2/files/blobs/" + layerDigest:
+			writer.Write([]byte(layer))
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, manifestDigest
+}
+
+func TestOCIFetcherAppliesHTTPConfig(t *testing.T) {
+	plainHTTP := OCIPlainHTTP
+	OCIPlainHTTP = true
+	defer func() { OCIPlainHTTP = plainHTTP }()
+
+	server, digest := newTestRegistry(t, "layer content")
+	credentials := filepath.Join(t.TempDir(), "registry")
+	if err := os.WriteFile(credentials, []byte("user:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := url.Parse(server.URL)
+	location, _ := url.Parse("oci://" + host.Host + "/files@" + digest)
+
+	tests := []struct {
+		name   string
+		config *HTTPConfig
+		err    bool
+	}{
+		{name: "with http options", config: &HTTPConfig{AuthType: HTTPAuthBasic, AuthFile: credentials, Headers: map[string]string{"X-Api-Key": "key"}}},
+		{name: "without http options", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fetcher := &ociFetcher{http: &httpFetcher{}}
+			response, err := fetcher.Fetch(context.Background(), &FetchRequest{URL: location, Name: "app.bin", HTTP: test.config})
+			if test.err {
+				if err == nil {
+					response.Body.Close()
+					t.Fatal("expected the registry to reject the requests")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			content, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "layer content" {
+				t.Errorf("expected the layer content, got %q", content)
+			}
+		})
+	}
+}
+
+func TestOCIFetcherSize(t *testing.T) {
+	plainHTTP := OCIPlainHTTP
+	OCIPlainHTTP = true
+	defer func() { OCIPlainHTTP = plainHTTP }()
+
+	server, digest := newTestRegistry(t, "layer content")
+	credentials := filepath.Join(t.TempDir(), "registry")
+	if err := os.WriteFile(credentials, []byte("user:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := url.Parse(server.URL)
+	layerSum := sha256.Sum256([]byte("layer content"))
+	config := &HTTPConfig{AuthType: HTTPAuthBasic, AuthFile: credentials, Headers: map[string]string{"X-Api-Key": "key"}}
+
+	tests := []struct {
+		name   string
+		digest string
+	}{
+		{name: "manifest", digest: digest},
+		{name: "blob", digest: "sha256:" + hex.EncodeToString(layerSum[:])},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location, _ := url.Parse("oci://" + host.Host + "/files@" + test.digest)
+			size, err := (&ociFetcher{http: &httpFetcher{}}).Size(context.Background(), &FetchRequest{URL: location, Name: "app.bin", HTTP: config})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != int64(len("layer content")) {
+				t.Errorf("expected size %d, got %d", len("layer content"), size)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3SignatureAlgorithm = "AWS4-HMAC-SHA256"
+	s3Service            = "s3"
+	s3UnsignedPayload    = "UNSIGNED-PAYLOAD"
+)
+
+// S3Config holds the settings for fetching artifacts from S3-compatible object storages via s3://<bucket>/<key> URLs
+type S3Config struct {
+	// Endpoint is the URL of the object storage, e.g. http://localhost:9000, defaults to the AWS endpoint of the region
+	Endpoint string
+	// Region is the region used for signing the requests
+	Region string
+	// PathStyle enables path-style (<endpoint>/<bucket>/<key>) instead of virtual-hosted-style (<bucket>.<endpoint>/<key>) requests
+	PathStyle bool
+}
+
+// S3 is the configuration of the S3 artifact source.
+// The credentials are taken from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables,
+// anonymous requests are sent if no credentials are set.
+var S3 = S3Config{
+	Region:    "us-east-1",
+	PathStyle: true,
+}
+
+func init() {
+	RegisterFetcher("s3", &s3Fetcher{http: &httpFetcher{}})
+}
+
+// s3Fetcher fetches artifacts from S3-compatible object storages, signing the requests with AWS Signature Version 4
+type s3Fetcher struct {
+	http *httpFetcher
+}
+
+type s3Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// Fetch sends a signed GET object request for the requested artifact
+func (f *s3Fetcher) Fetch(ctx context.Context, request *FetchRequest) (*FetchResponse, error) {
+	target, err := S3.objectURL(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	return f.http.get(ctx, target.String(), request, s3Signer())
+}
+
+// Size sends a signed HEAD object request for the requested artifact and returns the size of the object
+func (f *s3Fetcher) Size(ctx context.Context, request *FetchRequest) (int64, error) {
+	target, err := S3.objectURL(request.URL)
+	if err != nil {
+		return -1, err
+	}
+	return f.http.head(ctx, target.String(), request, s3Signer())
+}
+
+// s3Signer returns a function signing the requests with the credentials from the environment, requests are sent anonymously if no credentials are set
+func s3Signer() func(*http.Request) error {
+	credentials := &s3Credentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	return func(req *http.Request) error {
+		if credentials.accessKeyID == "" {
+			return nil
+		}
+		signS3Request(req, credentials, S3.Region, time.Now().UTC())
+		return nil
+	}
+}
+
+// objectURL translates an s3://<bucket>/<key> URL into the HTTP(S) URL of the object
+func (c S3Config) objectURL(location *url.URL) (*url.URL, error) {
+	bucket := location.Host
+	key := strings.TrimPrefix(location.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid s3 url [%s], expected s3://<bucket>/<key>", location.Redacted())
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if c.PathStyle {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		target.Host = bucket + "." + target.Host
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + key
+	}
+	return target, nil
+}
+
+// signS3Request adds the AWS Signature Version 4 authorization header to the given request
+func signS3Request(req *http.Request, credentials *s3Credentials, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3CanonicalURI(req.URL),
+		s3CanonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	stringToSign := strings.Join([]string{s3SignatureAlgorithm, amzDate, scope, hexSHA256(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SignatureAlgorithm, credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+func s3CanonicalURI(location *url.URL) string {
+	path := location.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err == nil {
+			segment = unescaped
+		}
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(location *url.URL) string {
+	query := location.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes all characters except the unreserved ones, as required by the signature
+func s3Escape(value string) string {
+	var sb strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	generationsDirectoryName = ".generations"
+	currentLinkName          = "current"
+)
+
+// KeepGenerations is the number of previous generations kept next to the current one for fast revert, it can be overridden per domain
+var KeepGenerations = 2
+
+// filesDirectory is the path of a directory managed by the agent, which holds the generations of the files of a single domain
+type filesDirectory string
+
+// generationsDirectory returns the directory holding all generations of the managed files
+func (d filesDirectory) generationsDirectory() string {
+	return filepath.Join(string(d), generationsDirectoryName)
+}
+
+// currentDirectory returns the symbolic link pointing to the active generation, applications read the managed files through it
+func (d filesDirectory) currentDirectory() string {
+	return filepath.Join(string(d), currentLinkName)
+}
+
+func (d filesDirectory) generationDirectory(generation int) string {
+	return filepath.Join(d.generationsDirectory(), strconv.Itoa(generation))
+}
+
+// initGenerations prepares the versioned layout of the files directory.
+// Files placed directly in the files directory, e.g. by earlier versions of the agent, are moved into the first generation.
+// The entries of the agent itself are left in place.
+func (d filesDirectory) initGenerations() error {
+	if _, err := os.Lstat(d.currentDirectory()); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	first := d.generationDirectory(1)
+	if err := os.MkdirAll(first, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if d.isAgentEntry(entry.Name()) {
+			continue
+		}
+		slog.Debug(fmt.Sprintf("moving [%s] to the first generation of the files directory", entry.Name()))
+		if err = os.Rename(filepath.Join(string(d), entry.Name()), filepath.Join(first, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return d.switchGeneration(1)
+}
+
+// isAgentEntry checks if the entry with the given name in the files directory is managed by the agent itself, e.g. the journal of an operation
+func (d filesDirectory) isAgentEntry(name string) bool {
+	switch name {
+	case generationsDirectoryName, downloadsDirectoryName, currentLinkName, currentLinkName + ".tmp", journalFileName, journalFileName + ".tmp":
+		return true
+	}
+	return false
+}
+
+// currentGeneration returns the generation the current link points to
+func (d filesDirectory) currentGeneration() (int, error) {
+	link, err := os.Readlink(d.currentDirectory())
+	if err != nil {
+		return 0, err
+	}
+	generation, err := strconv.Atoi(filepath.Base(link))
+	if err != nil {
+		return 0, fmt.Errorf("invalid generation link [%s]", link)
+	}
+	return generation, nil
+}
+
+// listGenerations returns all existing generations in ascending order
+func (d filesDirectory) listGenerations() ([]int, error) {
+	entries, err := os.ReadDir(d.generationsDirectory())
+	if err != nil {
+		return nil, err
+	}
+	generations := []int{}
+	for _, entry := range entries {
+		if generation, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+// nextGeneration returns a generation number greater than all existing ones
+func (d filesDirectory) nextGeneration() (int, error) {
+	generations, err := d.listGenerations()
+	if err != nil {
+		return 0, err
+	}
+	if len(generations) == 0 {
+		return 1, nil
+	}
+	return generations[len(generations)-1] + 1, nil
+}
+
+// switchGeneration atomically points the current link to the given generation by renaming a new link over it
+func (d filesDirectory) switchGeneration(generation int) error {
+	link := d.currentDirectory() + ".tmp"
+	if err := os.Remove(link); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Symlink(filepath.Join(generationsDirectoryName, strconv.Itoa(generation)), link); err != nil {
+		return err
+	}
+	if err := os.Rename(link, d.currentDirectory()); err != nil {
+		os.Remove(link)
+		return err
+	}
+	return syncDirectory(string(d))
+}
+
+// pruneGenerations removes all generations, except for the current one and the given number of generations preceding it.
+// Generations newer than the current one are left over by rolled back updates and are removed as well.
+func (d filesDirectory) pruneGenerations(keepGenerations int) error {
+	current, err := d.currentGeneration()
+	if err != nil {
+		return err
+	}
+	generations, err := d.listGenerations()
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for i := len(generations) - 1; i >= 0; i-- {
+		generation := generations[i]
+		if generation == current {
+			continue
+		}
+		if generation < current && kept < keepGenerations {
+			kept++
+			continue
+		}
+		slog.Debug(fmt.Sprintf("removing generation [%d] of the files directory", generation))
+		if err = os.RemoveAll(d.generationDirectory(generation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncDirectory(path string) error {
+	directory, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestInitGenerations(t *testing.T) {
+	directory := filesDirectory(filepath.Join(t.TempDir(), "files"))
+	if err := os.MkdirAll(filepath.Join(string(directory), "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", journalFileName, currentLinkName + ".tmp"} {
+		if err := os.WriteFile(filepath.Join(string(directory), name), []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the second initialization keeps the layout
+	for i := 0; i < 2; i++ {
+		if err := directory.initGenerations(); err != nil {
+			t.Fatal(err)
+		}
+		if generation, err := directory.currentGeneration(); err != nil || generation != 1 {
+			t.Fatalf("expected current generation 1, got %d (%v)", generation, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(directory.currentDirectory(), "a.txt")); err != nil || string(data) != "a" {
+		t.Errorf("expected the existing file to be moved into the first generation, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(directory.currentDirectory(), "dir")); err != nil || !info.IsDir() {
+		t.Errorf("expected the existing directory to be moved into the first generation, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(string(directory), "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the existing file to be moved, got %v", err)
+	}
+	for _, name := range []string{journalFileName, currentLinkName + ".tmp"} {
+		if _, err := os.Stat(filepath.Join(directory.currentDirectory(), name)); !os.IsNotExist(err) {
+			t.Errorf("expected [%s] of the agent not to be moved into the first generation, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(string(directory), journalFileName)); err != nil {
+		t.Errorf("expected the journal to be kept in place, got %v", err)
+	}
+}
+
+func TestPruneGenerations(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   int
+		keep      int
+		remaining []int
+	}{
+		{name: "keep previous generations", current: 5, keep: 2, remaining: []int{3, 4, 5}},
+		{name: "keep none", current: 5, keep: 0, remaining: []int{5}},
+		{name: "keep more than existing", current: 5, keep: 10, remaining: []int{1, 2, 3, 4, 5}},
+		{name: "newer generations of rolled back updates", current: 3, keep: 1, remaining: []int{2, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := filesDirectory(filepath.Join(t.TempDir(), "files"))
+			for generation := 1; generation <= 5; generation++ {
+				if err := os.MkdirAll(directory.generationDirectory(generation), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := directory.switchGeneration(test.current); err != nil {
+				t.Fatal(err)
+			}
+			if err := directory.pruneGenerations(test.keep); err != nil {
+				t.Fatal(err)
+			}
+			generations, err := directory.listGenerations()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(generations, test.remaining) {
+				t.Errorf("expected generations %v, got %v", test.remaining, generations)
+			}
+		})
+	}
+}
+
+func TestActivationSwitchesGeneration(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"v1.txt": "1", "v2.txt": "2"})
+	readCurrent := func() string {
+		data, err := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), "a.txt"))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	apply := func(activityID string, url string, commands ...types.CommandType) {
+		updMgr.Apply(context.Background(), activityID, newTestDesiredState(map[string]string{"a.txt": url}))
+		for _, command := range commands {
+			updMgr.Command(context.Background(), activityID, &types.DesiredStateCommand{Command: command})
+		}
+	}
+
+	apply("first", server.URL+"/v1.txt", types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup)
+	first, err := updMgr.directory.currentGeneration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apply("second", server.URL+"/v2.txt", types.CommandDownload, types.CommandUpdate)
+	if status := callback.last().status; status != types.BaselineStatusUpdateSuccess {
+		t.Fatalf("expected status %s, got %v", types.BaselineStatusUpdateSuccess, callback.statuses())
+	}
+	if content := readCurrent(); content != "1" {
+		t.Errorf("expected the current generation to be unchanged until activation, got %q", content)
+	}
+
+	updMgr.Command(context.Background(), "second", &types.DesiredStateCommand{Command: types.CommandActivate})
+	if content := readCurrent(); content != "2" {
+		t.Errorf("expected the new generation to be current after activation, got %q", content)
+	}
+	updMgr.Command(context.Background(), "second", &types.DesiredStateCommand{Command: types.CommandCleanup})
+	if generation, _ := updMgr.directory.currentGeneration(); generation == first {
+		t.Errorf("expected a new generation to be current, got %d", generation)
+	}
+	data, err := os.ReadFile(filepath.Join(updMgr.directory.generationDirectory(first), "a.txt"))
+	if err != nil || string(data) != "1" {
+		t.Errorf("expected the previous generation to be kept for revert, got %q (%v)", data, err)
+	}
+}
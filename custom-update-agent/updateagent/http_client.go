@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPTimeoutConfig defines the timeouts of the requests to HTTP based artifact sources, 0 disables a timeout
+type HTTPTimeoutConfig struct {
+	// Connect limits the time for establishing a connection, including the TLS handshake
+	Connect time.Duration
+	// ResponseHeader limits the time for receiving the response headers after the request is sent
+	ResponseHeader time.Duration
+	// IdleRead limits the time without receiving any content while a response body is read
+	IdleRead time.Duration
+}
+
+// HTTPTimeouts are the timeouts applied to the requests to HTTP based artifact sources
+var HTTPTimeouts = HTTPTimeoutConfig{
+	Connect:        30 * time.Second,
+	ResponseHeader: 30 * time.Second,
+	IdleRead:       60 * time.Second,
+}
+
+// newHTTPTransport returns a transport with the configured timeouts and the given TLS configuration.
+// Proxies are taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newHTTPTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   HTTPTimeouts.Connect,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   HTTPTimeouts.Connect,
+		ResponseHeaderTimeout: HTTPTimeouts.ResponseHeader,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+var defaultHTTPClient struct {
+	once   sync.Once
+	client *http.Client
+}
+
+// defaultClient returns the client used for requests without TLS settings.
+// It is created on first use, so that the configured timeouts are applied.
+func defaultClient() *http.Client {
+	defaultHTTPClient.once.Do(func() {
+		defaultHTTPClient.client = &http.Client{Transport: newHTTPTransport(nil)}
+	})
+	return defaultHTTPClient.client
+}
+
+type tlsClient struct {
+	stamp  string
+	client *http.Client
+}
+
+var tlsClients struct {
+	lock    sync.Mutex
+	clients map[string]*tlsClient
+}
+
+// clientFor returns the HTTP client for the given configuration. Clients with TLS settings are cached and shared by all requests with the same settings.
+func (f *httpFetcher) clientFor(config *HTTPConfig) (*http.Client, error) {
+	if !config.hasTLS() {
+		if f.client != nil {
+			return f.client, nil
+		}
+		return defaultClient(), nil
+	}
+	key, stamp := config.tlsStamp()
+
+	tlsClients.lock.Lock()
+	defer tlsClients.lock.Unlock()
+
+	cached := tlsClients.clients[key]
+	if cached != nil && cached.stamp == stamp {
+		return cached.client, nil
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		cached.client.CloseIdleConnections()
+	}
+	if tlsClients.clients == nil {
+		tlsClients.clients = map[string]*tlsClient{}
+	}
+	client := &http.Client{Transport: newHTTPTransport(tlsConfig)}
+	tlsClients.clients[key] = &tlsClient{stamp: stamp, client: client}
+	return client, nil
+}
+
+// do sends the given request with the client for the given configuration
+func (f *httpFetcher) do(req *http.Request, config *HTTPConfig) (*http.Response, error) {
+	client, err := f.clientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// idleTimeoutBody is a response body, whose request is cancelled if no content is received within the idle read timeout.
+// The request is cancelled on close as well.
+type idleTimeoutBody struct {
+	body     io.ReadCloser
+	url      string
+	timeout  time.Duration
+	timer    *time.Timer
+	cancel   context.CancelFunc
+	timedOut atomic.Bool
+}
+
+// newIdleTimeoutBody wraps the body of a response, the given function cancels its request
+func newIdleTimeoutBody(body io.ReadCloser, url string, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, url: url, timeout: HTTPTimeouts.IdleRead, cancel: cancel}
+	if b.timeout > 0 {
+		b.timer = time.AfterFunc(b.timeout, func() {
+			b.timedOut.Store(true)
+			cancel()
+		})
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		return b.body.Read(p)
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if err != nil && b.timedOut.Load() {
+		// reported as a timeout, so that the download is retried
+		return n, fmt.Errorf("no content received from [%s] for %s: %w", b.url, b.timeout, os.ErrDeadlineExceeded)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHTTPTimeouts(t *testing.T) {
+	timeouts := HTTPTimeouts
+	HTTPTimeouts = HTTPTimeoutConfig{Connect: time.Second, ResponseHeader: 200 * time.Millisecond, IdleRead: 200 * time.Millisecond}
+	defer func() { HTTPTimeouts = timeouts }()
+
+	// stall waits longer than the timeouts, unless the request is cancelled
+	stall := func(request *http.Request) {
+		select {
+		case <-request.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request)
+		err     bool
+	}{
+		{name: "fast response", handler: func(writer http.ResponseWriter, request *http.Request) { writer.Write([]byte(testContent)) }},
+		{
+			name: "slow response within idle read timeout",
+			handler: func(writer http.ResponseWriter, request *http.Request) {
+				for i := 0; i < 5; i++ {
+					writer.Write([]byte(testContent))
+					writer.(http.Flusher).Flush()
+					time.Sleep(50 * time.Millisecond)
+				}
+			},
+		},
+		{name: "response header timeout", handler: func(writer http.ResponseWriter, request *http.Request) { stall(request) }, err: true},
+		{
+			name: "idle read timeout",
+			handler: func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Length", "1000")
+				writer.Write([]byte(testContent))
+				writer.(http.Flusher).Flush()
+				stall(request)
+			},
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(test.handler))
+			t.Cleanup(server.Close)
+			location, _ := url.Parse(server.URL + "/app.bin")
+			fetcher := &httpFetcher{client: &http.Client{Transport: newHTTPTransport(nil)}}
+
+			start := time.Now()
+			response, err := fetcher.Fetch(context.Background(), &FetchRequest{URL: location, Name: "app.bin"})
+			if err == nil {
+				_, err = io.ReadAll(response.Body)
+				response.Body.Close()
+			}
+			if !test.err {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected the request to time out")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("expected the request to time out promptly, took %s", elapsed)
+			}
+			var netErr interface{ Timeout() bool }
+			if !errors.Is(err, os.ErrDeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				t.Errorf("expected a timeout error, got %v", err)
+			}
+			if retryable, _ := isRetryable(err); !retryable {
+				t.Errorf("expected the timeout to be retried, got %v", err)
+			}
+		})
+	}
+}
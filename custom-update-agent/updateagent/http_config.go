@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CredentialsDirectory is the directory with the secrets referenced by the HTTP options of the desired states, e.g. bearer tokens.
+// It can be overridden per domain. HTTP options referring to secrets are rejected if it is not set.
+var CredentialsDirectory string
+
+// Keys of the HTTP options, set either in the domain configuration of the desired state or in the configuration of a single component
+const (
+	httpOptionPrefix       = "http_"
+	httpOptionAuth         = "http_auth"
+	httpOptionHeaderPrefix = "http_header_"
+	httpOptionClientCert   = "http_client_cert"
+	httpOptionClientKey    = "http_client_key"
+	httpOptionCABundle     = "http_ca_bundle"
+)
+
+// HTTP authentication types
+const (
+	HTTPAuthNone   = "none"
+	HTTPAuthBearer = "bearer"
+	HTTPAuthBasic  = "basic"
+)
+
+// HTTPConfig holds the authentication, custom headers and TLS settings of the requests to HTTP based artifact sources.
+// Secrets are not part of the configuration, it refers to files on the device instead, which are read for each request.
+type HTTPConfig struct {
+	// AuthType is HTTPAuthBearer or HTTPAuthBasic, empty for anonymous requests
+	AuthType string
+	// AuthFile holds the bearer token or the basic credentials in the form <username>:<password>
+	AuthFile string
+	// Headers are the custom headers with literal values
+	Headers map[string]string
+	// HeaderFiles are the custom headers with values read from files
+	HeaderFiles map[string]string
+	// ClientCert and ClientKey are the PEM encoded client certificate and private key used for mutual TLS
+	ClientCert string
+	ClientKey  string
+	// CABundle holds the PEM encoded certificates trusted for the artifact hosts in addition to the system ones
+	CABundle string
+}
+
+// newHTTPConfig creates the HTTP configuration from the given HTTP options, where the options given later take precedence.
+// The referenced files must be inside the given credentials directory. It returns nil if no options are set.
+func newHTTPConfig(credentialsDirectory string, options ...map[string]string) (*HTTPConfig, error) {
+	merged := map[string]string{}
+	for _, opts := range options {
+		for key, value := range opts {
+			merged[key] = value
+		}
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+
+	config := &HTTPConfig{Headers: map[string]string{}, HeaderFiles: map[string]string{}}
+	credentialFile := func(key string, name string) (string, error) {
+		if credentialsDirectory == "" {
+			return "", fmt.Errorf("%s refers to file %s, but no credentials directory is configured", key, name)
+		}
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("%s must refer to a file inside the credentials directory, but got %s", key, name)
+		}
+		return filepath.Join(credentialsDirectory, name), nil
+	}
+	var err error
+	for key, value := range merged {
+		switch {
+		case key == httpOptionAuth:
+			authType, name, _ := strings.Cut(value, ":")
+			switch authType {
+			case HTTPAuthNone:
+			case HTTPAuthBearer, HTTPAuthBasic:
+				config.AuthType = authType
+				config.AuthFile, err = credentialFile(key, name)
+			default:
+				err = fmt.Errorf("%s must be %s, %s:<file> or %s:<file>, but got %s", key, HTTPAuthNone, HTTPAuthBearer, HTTPAuthBasic, value)
+			}
+		case strings.HasPrefix(key, httpOptionHeaderPrefix):
+			header := http.CanonicalHeaderKey(strings.TrimPrefix(key, httpOptionHeaderPrefix))
+			if header == "" {
+				err = fmt.Errorf("%s does not contain a header name", key)
+			} else if name, ok := strings.CutPrefix(value, "@"); ok {
+				config.HeaderFiles[header], err = credentialFile(key, name)
+			} else {
+				config.Headers[header] = value
+			}
+		case key == httpOptionClientCert:
+			config.ClientCert, err = credentialFile(key, value)
+		case key == httpOptionClientKey:
+			config.ClientKey, err = credentialFile(key, value)
+		case key == httpOptionCABundle:
+			config.CABundle, err = credentialFile(key, value)
+		default:
+			err = fmt.Errorf("unsupported HTTP option %s", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", httpOptionClientCert, httpOptionClientKey)
+	}
+	return config, nil
+}
+
+// apply sets the authorization and the custom headers of the given request, reading their secrets from the referenced files
+func (c *HTTPConfig) apply(req *http.Request) error {
+	if c == nil {
+		return nil
+	}
+	for header, value := range c.Headers {
+		req.Header.Set(header, value)
+	}
+	for header, file := range c.HeaderFiles {
+		value, err := readSecret(file)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(header, value)
+	}
+	if c.AuthType == "" {
+		return nil
+	}
+	secret, err := readSecret(c.AuthFile)
+	if err != nil {
+		return err
+	}
+	if c.AuthType == HTTPAuthBearer {
+		req.Header.Set("Authorization", "Bearer "+secret)
+		return nil
+	}
+	username, password, ok := strings.Cut(secret, ":")
+	if !ok {
+		return fmt.Errorf("basic credentials in file [%s] must be in the form <username>:<password>", c.AuthFile)
+	}
+	req.SetBasicAuth(username, password)
+	return nil
+}
+
+func readSecret(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *HTTPConfig) hasTLS() bool {
+	return c != nil && (c.ClientCert != "" || c.CABundle != "")
+}
+
+// tlsConfig loads the client certificate and the CA bundle
+func (c *HTTPConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate [%s]: %w", c.ClientCert, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if c.CABundle != "" {
+		data, err := os.ReadFile(c.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA bundle [%s]", c.CABundle)
+		}
+		config.RootCAs = roots
+	}
+	return config, nil
+}
+
+// tlsStamp identifies the TLS files of the configuration together with their modification times,
+// so that renewed certificates are loaded without restarting the agent
+func (c *HTTPConfig) tlsStamp() (string, string) {
+	key := strings.Join([]string{c.ClientCert, c.ClientKey, c.CABundle}, "|")
+	stamp := []string{}
+	for _, file := range []string{c.ClientCert, c.ClientKey, c.CABundle} {
+		if info, err := os.Stat(file); err == nil {
+			stamp = append(stamp, info.ModTime().String())
+		}
+	}
+	return key, strings.Join(stamp, "|")
+}
@@ -14,6 +14,10 @@ package updateagent
 
 import (
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
 
@@ -21,18 +25,21 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	domainConfigMaxConcurrentDownloads = "max_concurrent_downloads"
+	domainConfigBandwidthLimit         = "bandwidth_limit"
+	domainConfigDownloadWindows        = "download_windows"
+)
+
 type internalDesiredState struct {
 	desiredState *types.DesiredState
 	files        []*util.File
-}
 
-func (ds *internalDesiredState) findComponent(name string) types.Component {
-	for _, component := range ds.desiredState.Domains[0].Components {
-		if component.ID == name {
-			return component.Component
-		}
-	}
-	return types.Component{}
+	downloadConcurrency int
+	bandwidthLimit      int64
+	downloadWindows     string
+	// httpOptions are the HTTP options for all files, which can be overridden per component
+	httpOptions map[string]string
 }
 
 // toInternalDesiredState converts incoming desired state into an internal desired state structure
@@ -48,8 +55,106 @@ func toInternalDesiredState(desiredState *types.DesiredState, domainName string)
 		return nil, errors.Wrap(err, "cannot convert desired state components to container configurations")
 	}
 
-	return &internalDesiredState{
+	if err := validateFileLayout(files); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := validateFetchURL(file.DownloadURL); err != nil {
+			return nil, errors.Wrapf(err, "invalid download url for file %s", file.Name)
+		}
+		if file.HasPatch() {
+			if err := validateFetchURL(file.PatchURL); err != nil {
+				return nil, errors.Wrapf(err, "invalid patch url for file %s", file.Name)
+			}
+		}
+		if file.Signature == "" && file.SignatureURL != "" {
+			if err := validateFetchURL(file.SignatureURL); err != nil {
+				return nil, errors.Wrapf(err, "invalid signature url for file %s", file.Name)
+			}
+		}
+		if file.Certificate == "" && file.CertificateURL != "" {
+			if err := validateFetchURL(file.CertificateURL); err != nil {
+				return nil, errors.Wrapf(err, "invalid certificate url for file %s", file.Name)
+			}
+		}
+	}
+
+	internalState := &internalDesiredState{
 		desiredState: desiredState,
 		files:        files,
-	}, nil
+	}
+	if err := internalState.applyDomainConfig(desiredState.Domains[0].Config); err != nil {
+		return nil, errors.Wrap(err, "invalid domain configuration")
+	}
+	return internalState, nil
+}
+
+func (ds *internalDesiredState) applyDomainConfig(config []*types.KeyValuePair) error {
+	for _, kvPair := range config {
+		switch kvPair.Key {
+		case domainConfigMaxConcurrentDownloads:
+			value, err := strconv.Atoi(kvPair.Value)
+			if err != nil || value < 1 {
+				return fmt.Errorf("%s must be a positive number, but got %s", kvPair.Key, kvPair.Value)
+			}
+			ds.downloadConcurrency = value
+		case domainConfigBandwidthLimit:
+			value, err := strconv.ParseInt(kvPair.Value, 10, 64)
+			if err != nil || value < 1 {
+				return fmt.Errorf("%s must be a positive number of bytes per second, but got %s", kvPair.Key, kvPair.Value)
+			}
+			ds.bandwidthLimit = value
+		case domainConfigDownloadWindows:
+			if _, err := parseDownloadWindows(kvPair.Value); err != nil {
+				return errors.Wrapf(err, "invalid %s", kvPair.Key)
+			}
+			ds.downloadWindows = kvPair.Value
+		default:
+			if strings.HasPrefix(kvPair.Key, httpOptionPrefix) {
+				if ds.httpOptions == nil {
+					ds.httpOptions = map[string]string{}
+				}
+				ds.httpOptions[kvPair.Key] = kvPair.Value
+			}
+		}
+	}
+	return nil
+}
+
+// validateFileLayout checks that the desired files do not overlap in the managed directory
+func validateFileLayout(files []*util.File) error {
+	names := map[string]bool{stateFileName: true, stateFileName + ".tmp": true, legacyStateFileName: true}
+	for _, file := range files {
+		if names[file.Name] {
+			return fmt.Errorf("file name %s is reserved or used by more than one component", file.Name)
+		}
+		names[file.Name] = true
+	}
+	extractDirectories := map[string]string{}
+	for _, file := range files {
+		if !file.IsArchive() {
+			continue
+		}
+		topDirectory := strings.Split(filepath.ToSlash(file.ExtractTo), "/")[0]
+		if names[topDirectory] {
+			return fmt.Errorf("extract_to directory %s of archive %s overlaps with a file name", file.ExtractTo, file.Name)
+		}
+		for other, directory := range extractDirectories {
+			if isInside(directory, file.ExtractTo) || isInside(file.ExtractTo, directory) {
+				return fmt.Errorf("extract_to directory %s of archive %s overlaps with the one of archive %s", file.ExtractTo, file.Name, other)
+			}
+		}
+		extractDirectories[file.Name] = file.ExtractTo
+	}
+	return nil
+}
+
+// validateFetchURL checks that a fetcher is registered for the scheme of the given URL
+func validateFetchURL(location string) error {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	_, err = fetcherFor(parsed)
+	return err
 }
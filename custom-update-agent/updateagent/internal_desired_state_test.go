@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestApplyDomainConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config []*types.KeyValuePair
+		check  func(*internalDesiredState) bool
+		err    string
+	}{
+		{name: "no config", check: func(ds *internalDesiredState) bool { return ds.downloadConcurrency == 0 }},
+		{
+			name:   "max concurrent downloads",
+			config: []*types.KeyValuePair{{Key: domainConfigMaxConcurrentDownloads, Value: "2"}},
+			check:  func(ds *internalDesiredState) bool { return ds.downloadConcurrency == 2 },
+		},
+		{name: "zero concurrent downloads", config: []*types.KeyValuePair{{Key: domainConfigMaxConcurrentDownloads, Value: "0"}}, err: "must be a positive number"},
+		{name: "invalid concurrent downloads", config: []*types.KeyValuePair{{Key: domainConfigMaxConcurrentDownloads, Value: "many"}}, err: "must be a positive number"},
+		{
+			name:   "bandwidth limit",
+			config: []*types.KeyValuePair{{Key: domainConfigBandwidthLimit, Value: "1048576"}},
+			check:  func(ds *internalDesiredState) bool { return ds.bandwidthLimit == 1048576 },
+		},
+		{name: "zero bandwidth limit", config: []*types.KeyValuePair{{Key: domainConfigBandwidthLimit, Value: "0"}}, err: "must be a positive number of bytes per second"},
+		{name: "bandwidth limit with unit", config: []*types.KeyValuePair{{Key: domainConfigBandwidthLimit, Value: "1MB"}}, err: "must be a positive number of bytes per second"},
+		{
+			name:   "download windows",
+			config: []*types.KeyValuePair{{Key: domainConfigDownloadWindows, Value: "22:00-04:00"}},
+			check:  func(ds *internalDesiredState) bool { return ds.downloadWindows == "22:00-04:00" },
+		},
+		{name: "invalid download windows", config: []*types.KeyValuePair{{Key: domainConfigDownloadWindows, Value: "night"}}, err: "invalid download_windows"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := &internalDesiredState{}
+			err := ds.applyDomainConfig(test.config)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(ds) {
+				t.Errorf("unexpected desired state %+v", ds)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+const journalFileName = ".journal.json"
+
+// journal is the persisted state of the operation in progress, which allows the operation to survive agent restarts
+type journal struct {
+	ActivityID   string              `json:"activityId"`
+	DesiredState *types.DesiredState `json:"desiredState"`
+	// Status is the last reported baseline status. An in progress status (e.g. DOWNLOADING) means that the agent stopped during that phase.
+	Status             types.StatusType `json:"status"`
+	PreviousGeneration int              `json:"previousGeneration"`
+	Generation         int              `json:"generation,omitempty"`
+	Actions            []*journalAction `json:"actions"`
+	Reconcile          bool             `json:"reconcile,omitempty"`
+	// Paused is set if the download is paused until the next download window, the download is resumed instead of rolled back then
+	Paused bool `json:"paused,omitempty"`
+}
+
+type journalAction struct {
+	ActionType util.ActionType `json:"actionType"`
+	Desired    *util.File      `json:"desired,omitempty"`
+	Current    *util.File      `json:"current,omitempty"`
+	Feedback   *types.Action   `json:"feedback"`
+}
+
+// inProgressStatuses maps the commands to the baseline status reported while they are executed
+var inProgressStatuses = map[types.CommandType]types.StatusType{
+	types.CommandDownload: types.BaselineStatusDownloading,
+	types.CommandUpdate:   types.BaselineStatusUpdating,
+	types.CommandActivate: types.BaselineStatusActivating,
+	types.CommandCleanup:  types.BaselineStatusCleanup,
+}
+
+func (d filesDirectory) journalPath() string {
+	return filepath.Join(string(d), journalFileName)
+}
+
+// loadJournal reads the journal of the operation interrupted by an agent restart, nil is returned if there is no such operation
+func (d filesDirectory) loadJournal() (*journal, error) {
+	data, err := os.ReadFile(d.journalPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("cannot parse operation journal: %w", err)
+	}
+	return j, nil
+}
+
+// saveJournal atomically replaces the journal with the current state of the operation, recording the given baseline status
+func (o *operation) saveJournal(status types.StatusType) {
+	o.feedbackLock.Lock()
+	j := &journal{
+		ActivityID:         o.activityID,
+		DesiredState:       o.desiredState.desiredState,
+		Status:             status,
+		PreviousGeneration: o.previousGeneration,
+		Generation:         o.generation,
+		Reconcile:          o.reconcile,
+		Paused:             o.paused,
+	}
+	for _, action := range o.allActions.actions {
+		feedback := *action.feedbackAction
+		j.Actions = append(j.Actions, &journalAction{
+			ActionType: action.actionType,
+			Desired:    action.desired,
+			Current:    action.current,
+			Feedback:   &feedback,
+		})
+	}
+	o.feedbackLock.Unlock()
+
+	data, err := json.Marshal(j)
+	if err == nil {
+		err = writeFileAtomic(o.directory.journalPath(), data)
+	}
+	if err != nil {
+		slog.Error("got error saving operation journal", "error", err)
+	}
+}
+
+func (d filesDirectory) removeJournal() {
+	if err := os.Remove(d.journalPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("got error removing operation journal", "error", err)
+	}
+}
+
+// restore rebuilds the operation state recorded in the journal
+func (o *operation) restore(j *journal) error {
+	if err := o.prepareDirectories(); err != nil {
+		return err
+	}
+	o.previousGeneration = j.PreviousGeneration
+	o.generation = j.Generation
+	o.reconcile = j.Reconcile
+	o.paused = j.Paused
+	state, err := readState(o.directory.generationDirectory(o.previousGeneration))
+	if err != nil {
+		return err
+	}
+	o.currentState = state
+	actions := make([]*fileAction, len(j.Actions))
+	for i, action := range j.Actions {
+		actions[i] = &fileAction{
+			desired:        action.Desired,
+			current:        action.Current,
+			feedbackAction: action.Feedback,
+			actionType:     action.ActionType,
+		}
+	}
+	o.allActions = &action{status: j.Status, actions: actions}
+	return nil
+}
+
+// Recover resumes the operation interrupted by an agent restart from its journal.
+// If the agent stopped in the middle of a phase, the operation is rolled back, so that the Update Manager can retry it starting with download.
+// A download paused until the next download window is resumed when the window opens instead.
+// Otherwise, the last reported status is reported again and the operation waits for the next command.
+// Reconcile operations are finished right away, as no commands follow for them: unless already activated, they are rolled back and left to the next reconciliation.
+// It returns false if the operation is already finished.
+func (o *operation) Recover(ctx context.Context, j *journal) (bool, error) {
+	if err := o.restore(j); err != nil {
+		return false, err
+	}
+	slog.Info(fmt.Sprintf("recovering operation for activityId %s with last status %s", o.activityID, j.Status))
+	if o.reconcile {
+		if j.Status != types.BaselineStatusActivationSuccess && j.Status != types.BaselineStatusCleanup {
+			rollback(o, o.allActions)
+		}
+		o.Execute(ctx, types.CommandCleanup, "")
+		return false, nil
+	}
+	switch j.Status {
+	case types.BaselineStatusCleanup:
+		o.Execute(ctx, types.CommandCleanup, "")
+		return false, nil
+	case types.BaselineStatusDownloading:
+		if o.paused && o.schedulePausedDownload() {
+			o.Feedback(j.Status, "", "")
+		} else {
+			rollback(o, o.allActions)
+			o.saveJournal(o.allActions.status)
+		}
+	case types.BaselineStatusUpdating, types.BaselineStatusActivating, types.BaselineStatusRollback:
+		rollback(o, o.allActions)
+		o.saveJournal(o.allActions.status)
+	default:
+		o.Feedback(j.Status, "", "")
+	}
+	return true, nil
+}
+
+// writeFileAtomic writes the data to a temporary file, which then replaces the file at the given path
+func writeFileAtomic(path string, data []byte) error {
+	temp := path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDirectory(filepath.Dir(path))
+}
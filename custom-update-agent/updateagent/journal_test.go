@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// restartTestUpdateManager returns a new update manager of the directory of the given one, as if the agent was restarted
+func restartTestUpdateManager(t *testing.T, updMgr *fileUpdateManager) (*fileUpdateManager, *testCallback) {
+	t.Helper()
+	updMgr.Dispose()
+	restarted := newUpdateManager(NewDomainConfig("files", string(updMgr.directory))).(*fileUpdateManager)
+	callback := &testCallback{}
+	restarted.SetCallback(callback)
+	t.Cleanup(func() { restarted.Dispose() })
+	return restarted, callback
+}
+
+func TestRecoverOperation(t *testing.T) {
+	tests := []struct {
+		name string
+		// commands are executed before the restart
+		commands []types.CommandType
+		// interruptedStatus replaces the status recorded in the journal, as if the agent stopped during that phase
+		interruptedStatus types.StatusType
+		recoveredStatus   types.StatusType
+		inProgress        bool
+		// nextCommands are executed after the restart
+		nextCommands []types.CommandType
+		finalStatus  types.StatusType
+		content      string
+	}{
+		{
+			name:            "identified",
+			recoveredStatus: types.StatusIdentified,
+			inProgress:      true,
+			nextCommands:    []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup},
+			finalStatus:     types.BaselineStatusCleanupSuccess,
+			content:         "2",
+		},
+		{
+			name:            "downloaded",
+			commands:        []types.CommandType{types.CommandDownload},
+			recoveredStatus: types.BaselineStatusDownloadSuccess,
+			inProgress:      true,
+			nextCommands:    []types.CommandType{types.CommandUpdate, types.CommandActivate, types.CommandCleanup},
+			finalStatus:     types.BaselineStatusCleanupSuccess,
+			content:         "2",
+		},
+		{
+			name:              "interrupted download",
+			interruptedStatus: types.BaselineStatusDownloading,
+			recoveredStatus:   types.BaselineStatusRollbackSuccess,
+			inProgress:        true,
+			nextCommands:      []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup},
+			finalStatus:       types.BaselineStatusCleanupSuccess,
+			content:           "2",
+		},
+		{
+			name:              "interrupted update",
+			commands:          []types.CommandType{types.CommandDownload, types.CommandUpdate},
+			interruptedStatus: types.BaselineStatusUpdating,
+			recoveredStatus:   types.BaselineStatusRollbackSuccess,
+			inProgress:        true,
+			content:           "1",
+		},
+		{
+			name:              "interrupted cleanup",
+			commands:          []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate},
+			interruptedStatus: types.BaselineStatusCleanup,
+			recoveredStatus:   types.BaselineStatusCleanupSuccess,
+			content:           "2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updMgr, _ := newTestUpdateManager(t)
+			server := newTestServer(t, map[string]string{"v1.txt": "1", "v2.txt": "2"})
+			run := func(updMgr *fileUpdateManager, activityID string, commands ...types.CommandType) {
+				for _, command := range commands {
+					updMgr.Command(context.Background(), activityID, &types.DesiredStateCommand{Command: command})
+				}
+			}
+			updMgr.Apply(context.Background(), "first", newTestDesiredState(map[string]string{"a.txt": server.URL + "/v1.txt"}))
+			run(updMgr, "first", types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup)
+			updMgr.Apply(context.Background(), "second", newTestDesiredState(map[string]string{"a.txt": server.URL + "/v2.txt"}))
+			run(updMgr, "second", test.commands...)
+
+			if test.interruptedStatus != "" {
+				j, err := updMgr.directory.loadJournal()
+				if err != nil || j == nil {
+					t.Fatalf("expected a journal of the operation in progress, got %v", err)
+				}
+				j.Status = test.interruptedStatus
+				data, _ := json.Marshal(j)
+				if err = writeFileAtomic(updMgr.directory.journalPath(), data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restarted, callback := restartTestUpdateManager(t, updMgr)
+			restarted.recoverOperation()
+			if status := callback.last().status; status != test.recoveredStatus {
+				t.Fatalf("expected recovered status %s, got %v", test.recoveredStatus, callback.statuses())
+			}
+			if inProgress := restarted.operationInProgress.Load(); inProgress != test.inProgress {
+				t.Errorf("expected operation in progress %v, got %v", test.inProgress, inProgress)
+			}
+			if !test.inProgress {
+				if _, err := os.Stat(restarted.directory.journalPath()); !os.IsNotExist(err) {
+					t.Errorf("expected the journal of the finished operation to be removed, got %v", err)
+				}
+			}
+			if len(test.nextCommands) > 0 {
+				run(restarted, "second", test.nextCommands...)
+				if status := callback.last().status; status != test.finalStatus {
+					t.Fatalf("expected status %s, got %v", test.finalStatus, callback.statuses())
+				}
+			}
+			data, err := os.ReadFile(filepath.Join(restarted.directory.currentDirectory(), "a.txt"))
+			if err != nil || string(data) != test.content {
+				t.Errorf("expected current content %q, got %q (%v)", test.content, data, err)
+			}
+		})
+	}
+}
+
+func TestRecoverWithoutJournal(t *testing.T) {
+	updMgr, _ := newTestUpdateManager(t)
+	restarted, callback := restartTestUpdateManager(t, updMgr)
+	restarted.recoverOperation()
+	if statuses := callback.statuses(); len(statuses) != 0 || restarted.operationInProgress.Load() {
+		t.Errorf("expected no operation to be recovered, got %v", statuses)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+// Reconciliation policies
+const (
+	// ReconcilePolicyReport reports the drift of the managed files via a current state event
+	ReconcilePolicyReport = "report"
+	// ReconcilePolicyRepair re-applies the last applied desired state when the managed files drift from it
+	ReconcilePolicyRepair = "repair"
+)
+
+// ReconcileInterval is the interval of checking the managed files for drift from the last applied desired state, 0 disables the reconciliation.
+// It can be overridden per domain.
+var ReconcileInterval time.Duration
+
+// ReconcilePolicy is the action taken on drift of the managed files, either ReconcilePolicyReport or ReconcilePolicyRepair.
+// It can be overridden per domain.
+var ReconcilePolicy = ReconcilePolicyReport
+
+// reconcileCommands are the commands executed by a reconcile operation, mapped to the baseline status expected after each of them
+var reconcileCommands = []struct {
+	command types.CommandType
+	success types.StatusType
+}{
+	{types.CommandDownload, types.BaselineStatusDownloadSuccess},
+	{types.CommandUpdate, types.BaselineStatusUpdateSuccess},
+	{types.CommandActivate, types.BaselineStatusActivationSuccess},
+}
+
+// reconcileFiles periodically checks the managed files for drift until the context is done
+func (updMgr *fileUpdateManager) reconcileFiles(ctx context.Context) {
+	slog.Debug(fmt.Sprintf("reconciling files directory of domain %s every %s with policy %s", updMgr.domainName, updMgr.config.ReconcileInterval, updMgr.config.ReconcilePolicy))
+	ticker := time.NewTicker(updMgr.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	lastDrift := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lastDrift = updMgr.reconcile(ctx, lastDrift)
+		}
+	}
+}
+
+// reconcile compares the current generation with the last applied desired state and its state file.
+// Drift is reported only if it differs from the last reported one, given as a fingerprint, and the new fingerprint is returned.
+// With the repair policy, the last applied desired state is applied again instead.
+func (updMgr *fileUpdateManager) reconcile(ctx context.Context, lastDrift string) string {
+	updMgr.applyLock.Lock()
+	defer updMgr.applyLock.Unlock()
+
+	if updMgr.operationInProgress.Load() {
+		slog.Debug("skipping reconciliation, update operation in progress")
+		return lastDrift
+	}
+	state, files, err := updMgr.checkDrift()
+	if err != nil {
+		slog.Error("got error checking files directory for drift", "error", err)
+		return lastDrift
+	}
+	drift := driftFingerprint(files)
+	if drift != "" && updMgr.config.ReconcilePolicy == ReconcilePolicyRepair && state.DesiredState != nil {
+		slog.Info("files directory drifted from the last applied desired state, repairing", "drift", drift)
+		updMgr.repair(ctx, state.DesiredState)
+		_, files, err = updMgr.checkDrift()
+		if err != nil {
+			slog.Error("got error checking files directory for drift", "error", err)
+			return lastDrift
+		}
+		drift = driftFingerprint(files)
+	} else if drift == lastDrift {
+		return lastDrift
+	}
+	if drift != "" {
+		slog.Warn("files directory drifted from the last applied desired state", "drift", drift)
+	}
+	updMgr.eventCallback.HandleCurrentStateEvent(updMgr.Name(), "", toInventory(updMgr.asSoftwareNode(), util.FromFiles(files)))
+	return drift
+}
+
+// checkDrift returns the state of the current generation and its files marked with their drift,
+// including unexpected files and files of the last applied desired state missing from the state file
+func (updMgr *fileUpdateManager) checkDrift() (*fileState, []*util.File, error) {
+	directory := updMgr.directory.currentDirectory()
+	state, err := readState(directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := state.files()
+	for _, file := range files {
+		detectDrift(directory, file)
+	}
+	unexpected, err := unexpectedFiles(directory, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	files = append(files, unexpected...)
+
+	if state.DesiredState != nil {
+		desiredState, err := toInternalDesiredState(state.DesiredState, updMgr.domainName)
+		if err != nil {
+			return nil, nil, err
+		}
+		recorded := util.AsNamedMap(files)
+		for _, desired := range desiredState.files {
+			if recorded[desired.Name] == nil {
+				missing := *desired
+				missing.Drift = util.DriftMissing
+				files = append(files, &missing)
+			}
+		}
+	}
+	return state, files, nil
+}
+
+// repair applies the given desired state again through an operation, which is not reported to the Update Manager.
+// The repair is cancelled when a request of the Update Manager is received, which is processed after the repair is cleaned up.
+func (updMgr *fileUpdateManager) repair(ctx context.Context, desiredState *types.DesiredState) {
+	ctx, cancel := updMgr.operationContext(ctx)
+	defer cancel()
+	if !updMgr.startRepair(cancel) {
+		slog.Debug("skipping repair, request of the update manager pending")
+		return
+	}
+	defer updMgr.finishRepair()
+
+	internalDesiredState, err := toInternalDesiredState(desiredState, updMgr.domainName)
+	if err != nil {
+		slog.Error("could not parse last applied desired state", "error", err)
+		return
+	}
+	o := newReconcileOperation(updMgr, fmt.Sprintf("reconcile-%d", time.Now().UnixNano()), internalDesiredState)
+	hasActions, err := o.Identify(ctx)
+	if err != nil {
+		slog.Error("reconciliation - identification phase failed", "error", err)
+		return
+	}
+	if !hasActions {
+		return
+	}
+
+	updMgr.operationInProgress.Store(true)
+	defer updMgr.operationInProgress.Store(false)
+	for _, step := range reconcileCommands {
+		o.Execute(ctx, step.command, "")
+		if o.allActions.status != step.success {
+			slog.Error(fmt.Sprintf("reconciliation - %s failed with status %s", step.command, o.allActions.status))
+			break
+		}
+	}
+	o.Execute(ctx, types.CommandCleanup, "")
+}
+
+// startRepair registers the cancellation of a repair to be started, unless a request of the Update Manager is already waiting for the apply lock
+func (updMgr *fileUpdateManager) startRepair(cancel context.CancelFunc) bool {
+	updMgr.repairLock.Lock()
+	defer updMgr.repairLock.Unlock()
+
+	if updMgr.pendingRequests > 0 {
+		return false
+	}
+	updMgr.cancelRepair = cancel
+	return true
+}
+
+func (updMgr *fileUpdateManager) finishRepair() {
+	updMgr.repairLock.Lock()
+	defer updMgr.repairLock.Unlock()
+
+	updMgr.cancelRepair = nil
+}
+
+func newReconcileOperation(updMgr *fileUpdateManager, activityID string, desiredState *internalDesiredState) *operation {
+	return &operation{
+		directory:     updMgr.directory,
+		updateManager: updMgr,
+		activityID:    activityID,
+		desiredState:  desiredState,
+		client:        newDownloadClient(updMgr.config.CredentialsDirectory, desiredState.httpOptions),
+		reconcile:     true,
+	}
+}
+
+// driftFingerprint returns a stable description of the drifted files, empty if there is no drift
+func driftFingerprint(files []*util.File) string {
+	drifted := []string{}
+	for _, file := range files {
+		if file.Drift != "" {
+			drifted = append(drifted, file.Name+"="+file.Drift)
+		}
+	}
+	sort.Strings(drifted)
+	return strings.Join(drifted, ",")
+}
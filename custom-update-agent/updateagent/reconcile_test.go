@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		modify  func(directory string) error
+		drift   string
+		content string
+	}{
+		{name: "no drift", policy: ReconcilePolicyReport, content: "a"},
+		{name: "reported modified file", policy: ReconcilePolicyReport, modify: writeTestFile("a.txt", "other"), drift: "a.txt=modified", content: "other"},
+		{name: "reported missing file", policy: ReconcilePolicyReport, modify: removeTestFile("a.txt"), drift: "a.txt=missing"},
+		{name: "reported unexpected file", policy: ReconcilePolicyReport, modify: writeTestFile("c.txt", "c"), drift: "c.txt=unexpected", content: "a"},
+		{name: "repaired modified file", policy: ReconcilePolicyRepair, modify: writeTestFile("a.txt", "other"), content: "a"},
+		{name: "repaired missing file", policy: ReconcilePolicyRepair, modify: removeTestFile("a.txt"), content: "a"},
+		{name: "repaired unexpected file", policy: ReconcilePolicyRepair, modify: writeTestFile("c.txt", "c"), content: "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updMgr, callback := newTestUpdateManager(t)
+			updMgr.config.ReconcilePolicy = test.policy
+			server := newTestServer(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+			updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt", "b.txt": server.URL + "/b.txt"}))
+			for _, command := range []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup} {
+				updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: command})
+			}
+			if status := callback.last().status; status != types.BaselineStatusCleanupSuccess {
+				t.Fatalf("expected last status %s, got %v", types.BaselineStatusCleanupSuccess, callback.statuses())
+			}
+			feedback := len(callback.statuses())
+			events := callback.currentStateEvents()
+			if test.modify != nil {
+				if err := test.modify(updMgr.directory.currentDirectory()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			drift := updMgr.reconcile(context.Background(), "")
+			if drift != test.drift {
+				t.Errorf("expected drift [%s], got [%s]", test.drift, drift)
+			}
+			// the current state is reported after a repair or on new drift
+			expected := 0
+			if test.modify != nil {
+				expected = 1
+			}
+			if reported := callback.currentStateEvents() - events; reported != expected {
+				t.Errorf("expected %d current state events, got %d", expected, reported)
+			}
+			data, _ := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), "a.txt"))
+			if string(data) != test.content {
+				t.Errorf("expected content %q, got %q", test.content, data)
+			}
+			if test.policy == ReconcilePolicyRepair {
+				if _, err := os.Stat(filepath.Join(updMgr.directory.currentDirectory(), "c.txt")); err == nil {
+					t.Error("expected the unexpected file to be removed by the repair")
+				}
+			}
+			if statuses := callback.statuses(); len(statuses) != feedback {
+				t.Errorf("expected no desired state feedback for the reconciliation, got %v", statuses[feedback:])
+			}
+
+			// the same drift is reported only once
+			events = callback.currentStateEvents()
+			if again := updMgr.reconcile(context.Background(), drift); again != drift {
+				t.Errorf("expected drift [%s], got [%s]", drift, again)
+			}
+			if reported := callback.currentStateEvents() - events; reported != 0 {
+				t.Errorf("expected no current state event for unchanged drift, got %d", reported)
+			}
+		})
+	}
+}
+
+func TestReconcileSkippedDuringOperation(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	updMgr.config.ReconcilePolicy = ReconcilePolicyRepair
+	if err := writeTestFile("a.txt", "a")(updMgr.directory.currentDirectory()); err != nil {
+		t.Fatal(err)
+	}
+
+	updMgr.operationInProgress.Store(true)
+	if drift := updMgr.reconcile(context.Background(), "last"); drift != "last" {
+		t.Errorf("expected the last drift to be kept, got [%s]", drift)
+	}
+	if events := callback.currentStateEvents(); events != 0 {
+		t.Errorf("expected no current state event during an operation, got %d", events)
+	}
+	if _, err := os.Stat(filepath.Join(updMgr.directory.currentDirectory(), "a.txt")); err != nil {
+		t.Errorf("expected the files to be left unchanged during an operation, got %v", err)
+	}
+}
+
+func TestRepairCancelledByApply(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	updMgr.config.ReconcilePolicy = ReconcilePolicyRepair
+	var stalled atomic.Bool
+	started := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if stalled.Load() && request.Method == http.MethodGet {
+			// the download of the repair stalls until it is cancelled
+			once.Do(func() { close(started) })
+			<-request.Context().Done()
+			return
+		}
+		writer.Write([]byte("a"))
+	}))
+	t.Cleanup(server.Close)
+	installTestFiles(t, updMgr, callback, "activity", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}))
+	if err := removeTestFile("a.txt")(updMgr.directory.currentDirectory()); err != nil {
+		t.Fatal(err)
+	}
+
+	stalled.Store(true)
+	reconciled := make(chan string)
+	go func() {
+		reconciled <- updMgr.reconcile(context.Background(), "")
+	}()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the repair to download the missing file")
+	}
+
+	applied := make(chan struct{})
+	go func() {
+		updMgr.Apply(context.Background(), "other", newTestDesiredState(map[string]string{"b.txt": newTestServer(t, map[string]string{"b.txt": "b"}).URL + "/b.txt"}))
+		close(applied)
+	}()
+	select {
+	case <-applied:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the repair to be cancelled by the received desired state")
+	}
+	if drift := <-reconciled; drift != "a.txt=missing" {
+		t.Errorf("expected the drift of the cancelled repair [a.txt=missing], got [%s]", drift)
+	}
+	if status := callback.last().status; status != types.StatusIdentified {
+		t.Errorf("expected last status %s, got %v", types.StatusIdentified, callback.statuses())
+	}
+}
@@ -0,0 +1,563 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+const (
+	signatureMaxBytes = 1024 * 1024
+	// ed25519MaxPureBytes is the maximum size of files verified with pure Ed25519 signatures, which cover the whole content and need it in memory.
+	// Larger files must be signed with Ed25519ph over their SHA-512 digest.
+	ed25519MaxPureBytes = 16 * 1024 * 1024
+	// tlogDirectory is the subdirectory of the trust store with the public keys of the trusted transparency logs
+	tlogDirectory = "tlog"
+)
+
+var (
+	// TrustStore is the directory with the PEM encoded public keys and root certificates trusted for signature verification
+	TrustStore = ""
+	// RequireSignatures enforces that every downloaded file has a valid signature
+	RequireSignatures = false
+)
+
+// trustStore holds the trusted public keys and root certificates loaded from the trust store directory,
+// as well as the public keys of the transparency logs trusted for the signed timestamps of signatures
+type trustStore struct {
+	keys     []crypto.PublicKey
+	roots    *x509.CertPool
+	tlogKeys []crypto.PublicKey
+}
+
+// signature is a detached signature of a file, optionally accompanied by the certificate chain of the signing key
+type signature struct {
+	value  []byte
+	digest []byte
+	chain  []*x509.Certificate
+	// entries are the transparency log entries of the signature, which prove the time the signature was created at
+	entries []*tlogEntry
+}
+
+// tlogEntry is an entry of a Rekor transparency log together with the signed entry timestamp issued by the log
+type tlogEntry struct {
+	// body is the base64 encoded canonicalized body of the entry
+	body           string
+	integratedTime int64
+	logIndex       int64
+	// logID is the hex encoded ID of the log
+	logID                string
+	signedEntryTimestamp []byte
+}
+
+// hashedRekord is the body of a transparency log entry of a detached signature
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// cosignBundle covers both the legacy cosign bundle (--bundle) and the Sigstore bundle formats
+type cosignBundle struct {
+	Base64Signature string `json:"base64Signature"`
+	Cert            string `json:"cert"`
+	RekorBundle     *struct {
+		SignedEntryTimestamp string `json:"SignedEntryTimestamp"`
+		Payload              struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogIndex       int64  `json:"logIndex"`
+			LogID          string `json:"logID"`
+		} `json:"Payload"`
+	} `json:"rekorBundle"`
+
+	MessageSignature *struct {
+		MessageDigest *struct {
+			Algorithm string `json:"algorithm"`
+			Digest    string `json:"digest"`
+		} `json:"messageDigest"`
+		Signature string `json:"signature"`
+	} `json:"messageSignature"`
+	VerificationMaterial *struct {
+		Certificate *struct {
+			RawBytes string `json:"rawBytes"`
+		} `json:"certificate"`
+		X509CertificateChain *struct {
+			Certificates []struct {
+				RawBytes string `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []struct {
+			LogIndex string `json:"logIndex"`
+			LogID    struct {
+				KeyID string `json:"keyId"`
+			} `json:"logId"`
+			IntegratedTime   string `json:"integratedTime"`
+			InclusionPromise *struct {
+				SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+			} `json:"inclusionPromise"`
+			CanonicalizedBody string `json:"canonicalizedBody"`
+		} `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+}
+
+// loadTrustStore reads all PEM blocks from the files in the given directory.
+// CERTIFICATE blocks are trusted as root certificates, PUBLIC KEY blocks as signing keys.
+// The PUBLIC KEY blocks in the files of its tlog subdirectory are trusted as transparency log keys.
+func loadTrustStore(directory string) (*trustStore, error) {
+	if directory == "" {
+		return nil, errors.New("no trust store is configured for signature verification")
+	}
+	store := &trustStore{roots: x509.NewCertPool()}
+	err := readPEMFiles(directory, func(file string, block *pem.Block) error {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("invalid certificate in trust store file [%s]: %w", file, err)
+			}
+			store.roots.AddCert(cert)
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("invalid public key in trust store file [%s]: %w", file, err)
+			}
+			store.keys = append(store.keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readPEMFiles(filepath.Join(directory, tlogDirectory), func(file string, block *pem.Block) error {
+		if block.Type != "PUBLIC KEY" {
+			return nil
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid transparency log key in trust store file [%s]: %w", file, err)
+		}
+		store.tlogKeys = append(store.tlogKeys, key)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return store, nil
+}
+
+// readPEMFiles passes the PEM blocks of the files in the given directory to the given handler
+func readPEMFiles(directory string, handle func(file string, block *pem.Block) error) error {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return fmt.Errorf("cannot read trust store: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("cannot read trust store: %w", err)
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if err = handle(entry.Name(), block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSignature retrieves the signature of the desired file, either inline or from its signature URL, together with its certificate chain, if any
+func (o *operation) loadSignature(ctx context.Context, desired *util.File) (*signature, error) {
+	var content []byte
+	if desired.Signature != "" {
+		content = []byte(desired.Signature)
+	} else {
+		data, err := o.client.fetchContent(ctx, desired, desired.SignatureURL, desired.Name+" signature", signatureMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot download signature of file [%s]: %w", desired.Name, err)
+		}
+		content = data
+	}
+	sig, err := parseSignature(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature of file [%s]: %w", desired.Name, err)
+	}
+
+	var certificates []byte
+	if desired.Certificate != "" {
+		certificates = []byte(desired.Certificate)
+	} else if desired.CertificateURL != "" {
+		if certificates, err = o.client.fetchContent(ctx, desired, desired.CertificateURL, desired.Name+" certificate", signatureMaxBytes); err != nil {
+			return nil, fmt.Errorf("cannot download certificate of file [%s]: %w", desired.Name, err)
+		}
+	}
+	if certificates != nil {
+		if sig.chain, err = parseCertificates(certificates); err != nil {
+			return nil, fmt.Errorf("invalid certificate of file [%s]: %w", desired.Name, err)
+		}
+	}
+	return sig, nil
+}
+
+// parseSignature parses a cosign or Sigstore bundle, a base64 encoded signature or a raw binary signature
+func parseSignature(content []byte) (*signature, error) {
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		return parseCosignBundle(trimmed)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(trimmed)); err == nil {
+		return &signature{value: decoded}, nil
+	}
+	return &signature{value: content}, nil
+}
+
+func parseCosignBundle(content []byte) (*signature, error) {
+	bundle := &cosignBundle{}
+	if err := json.Unmarshal(content, bundle); err != nil {
+		return nil, err
+	}
+	sig := &signature{}
+	encoded := bundle.Base64Signature
+	if bundle.MessageSignature != nil {
+		encoded = bundle.MessageSignature.Signature
+		if digest := bundle.MessageSignature.MessageDigest; digest != nil && digest.Digest != "" {
+			if digest.Algorithm != "SHA2_256" {
+				return nil, fmt.Errorf("unsupported message digest algorithm [%s]", digest.Algorithm)
+			}
+			value, err := base64.StdEncoding.DecodeString(digest.Digest)
+			if err != nil {
+				return nil, err
+			}
+			sig.digest = value
+		}
+	}
+	if encoded == "" {
+		return nil, errors.New("the bundle contains no signature")
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	sig.value = value
+
+	if bundle.Cert != "" {
+		if sig.chain, err = parseCertificates([]byte(bundle.Cert)); err != nil {
+			return nil, err
+		}
+	}
+	if rekor := bundle.RekorBundle; rekor != nil {
+		set, err := base64.StdEncoding.DecodeString(rekor.SignedEntryTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signed entry timestamp: %w", err)
+		}
+		sig.entries = append(sig.entries, &tlogEntry{
+			body:                 rekor.Payload.Body,
+			integratedTime:       rekor.Payload.IntegratedTime,
+			logIndex:             rekor.Payload.LogIndex,
+			logID:                rekor.Payload.LogID,
+			signedEntryTimestamp: set,
+		})
+	}
+	if material := bundle.VerificationMaterial; material != nil {
+		var encodedCerts []string
+		if material.Certificate != nil {
+			encodedCerts = append(encodedCerts, material.Certificate.RawBytes)
+		}
+		if material.X509CertificateChain != nil {
+			for _, cert := range material.X509CertificateChain.Certificates {
+				encodedCerts = append(encodedCerts, cert.RawBytes)
+			}
+		}
+		for _, encodedCert := range encodedCerts {
+			der, err := base64.StdEncoding.DecodeString(encodedCert)
+			if err != nil {
+				return nil, err
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			sig.chain = append(sig.chain, cert)
+		}
+		for _, entry := range material.TlogEntries {
+			if entry.InclusionPromise == nil {
+				continue
+			}
+			parsed := &tlogEntry{body: entry.CanonicalizedBody}
+			keyID, err := base64.StdEncoding.DecodeString(entry.LogID.KeyID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transparency log ID: %w", err)
+			}
+			parsed.logID = hex.EncodeToString(keyID)
+			if parsed.integratedTime, err = strconv.ParseInt(entry.IntegratedTime, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid transparency log entry time: %w", err)
+			}
+			if parsed.logIndex, err = strconv.ParseInt(entry.LogIndex, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid transparency log entry index: %w", err)
+			}
+			if parsed.signedEntryTimestamp, err = base64.StdEncoding.DecodeString(entry.InclusionPromise.SignedEntryTimestamp); err != nil {
+				return nil, fmt.Errorf("invalid signed entry timestamp: %w", err)
+			}
+			sig.entries = append(sig.entries, parsed)
+		}
+	}
+	return sig, nil
+}
+
+// parseCertificates parses a PEM encoded certificate chain, leaf certificate first, which may also be base64 encoded as a whole
+func parseCertificates(content []byte) ([]*x509.Certificate, error) {
+	trimmed := bytes.TrimSpace(content)
+	if !bytes.Contains(trimmed, []byte("-----BEGIN")) {
+		decoded, err := base64.StdEncoding.DecodeString(string(trimmed))
+		if err != nil {
+			return x509.ParseCertificates(content)
+		}
+		if !bytes.Contains(decoded, []byte("-----BEGIN")) {
+			return x509.ParseCertificates(decoded)
+		}
+		trimmed = decoded
+	}
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(trimmed); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return chain, nil
+}
+
+// verify checks the signature of the file at the given path.
+// If the signature comes with a certificate chain, the chain must lead to a trusted root certificate and the file must be signed with the leaf certificate key.
+// The chain is verified at the time of the transparency log entry of the signature, if the trust store has keys of transparency logs, and at the current time otherwise.
+// Without a certificate chain, the file must be signed with one of the trusted public keys.
+func (s *trustStore) verify(sig *signature, path string) error {
+	name := filepath.Base(path)
+	digests, err := digestFile(path)
+	if err != nil {
+		return err
+	}
+	if sig.digest != nil && !bytes.Equal(sig.digest, digests.sha256) {
+		return fmt.Errorf("the signature of file [%s] was created for different content", name)
+	}
+
+	keys := s.keys
+	if len(sig.chain) > 0 {
+		signedAt := time.Now()
+		if len(sig.entries) > 0 && len(s.tlogKeys) > 0 {
+			if signedAt, err = s.verifyEntries(sig, digests.sha256); err != nil {
+				return fmt.Errorf("invalid transparency log entry of the signature of file [%s]: %w", name, err)
+			}
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range sig.chain[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := sig.chain[0].Verify(x509.VerifyOptions{
+			Roots:         s.roots,
+			Intermediates: intermediates,
+			CurrentTime:   signedAt,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		})
+		if err != nil {
+			return fmt.Errorf("untrusted signing certificate for file [%s]: %w", name, err)
+		}
+		keys = []crypto.PublicKey{sig.chain[0].PublicKey}
+	}
+
+	for _, key := range keys {
+		if ok, err := verifyWithKey(key, sig.value, path, digests); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("the signature of file [%s] cannot be verified with any trusted key", name)
+}
+
+// verifyEntries checks that a transparency log entry of the signature is signed by a trusted log and records the given signature and file digest.
+// It returns the time the entry was integrated into the log.
+func (s *trustStore) verifyEntries(sig *signature, digest []byte) (time.Time, error) {
+	var err error
+	for _, entry := range sig.entries {
+		if err = s.verifyEntry(entry, sig.value, digest); err == nil {
+			return time.Unix(entry.integratedTime, 0), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func (s *trustStore) verifyEntry(entry *tlogEntry, value []byte, digest []byte) error {
+	var key crypto.PublicKey
+	for _, tlogKey := range s.tlogKeys {
+		der, err := x509.MarshalPKIXPublicKey(tlogKey)
+		if err != nil {
+			continue
+		}
+		if id := sha256.Sum256(der); hex.EncodeToString(id[:]) == entry.logID {
+			key = tlogKey
+			break
+		}
+	}
+	if key == nil {
+		return fmt.Errorf("untrusted transparency log [%s]", entry.logID)
+	}
+
+	// the signed entry timestamp is created over the canonical JSON of the entry, whose keys are sorted
+	payload, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{entry.body, entry.integratedTime, entry.logID, entry.logIndex})
+	if err != nil {
+		return err
+	}
+	payloadDigest := sha256.Sum256(payload)
+	switch publicKey := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, payloadDigest[:], entry.signedEntryTimestamp) {
+			return errors.New("invalid signed entry timestamp")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, payload, entry.signedEntryTimestamp) {
+			return errors.New("invalid signed entry timestamp")
+		}
+	default:
+		return fmt.Errorf("unsupported key of transparency log [%s]", entry.logID)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(entry.body)
+	if err != nil {
+		return fmt.Errorf("invalid entry body: %w", err)
+	}
+	rekord := &hashedRekord{}
+	if err = json.Unmarshal(body, rekord); err != nil {
+		return fmt.Errorf("invalid entry body: %w", err)
+	}
+	if rekord.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported entry kind [%s]", rekord.Kind)
+	}
+	if content, err := base64.StdEncoding.DecodeString(rekord.Spec.Signature.Content); err != nil || !bytes.Equal(content, value) {
+		return errors.New("the entry records another signature")
+	}
+	if rekord.Spec.Data.Hash.Algorithm != "sha256" || !strings.EqualFold(rekord.Spec.Data.Hash.Value, hex.EncodeToString(digest)) {
+		return errors.New("the entry records another file digest")
+	}
+	return nil
+}
+
+// verifyWithKey checks the signature with the given public key. ECDSA and RSA signatures are verified over the SHA-256 digest of the file.
+// Ed25519 signatures are verified as Ed25519ph signatures over the SHA-512 digest of the file and, for files not larger than ed25519MaxPureBytes,
+// also as pure Ed25519 signatures over the whole file content.
+func verifyWithKey(key crypto.PublicKey, value []byte, path string, digests *fileDigests) (bool, error) {
+	switch publicKey := key.(type) {
+	case ed25519.PublicKey:
+		if ed25519.VerifyWithOptions(publicKey, digests.sha512, value, &ed25519.Options{Hash: crypto.SHA512}) == nil {
+			return true, nil
+		}
+		if digests.size > ed25519MaxPureBytes {
+			return false, nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		return ed25519.Verify(publicKey, content, value), nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(publicKey, digests.sha256, value), nil
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digests.sha256, value) == nil {
+			return true, nil
+		}
+		return rsa.VerifyPSS(publicKey, crypto.SHA256, digests.sha256, value, nil) == nil, nil
+	}
+	return false, nil
+}
+
+// fileDigests are the digests of a file that signatures are verified over
+type fileDigests struct {
+	sha256 []byte
+	sha512 []byte
+	size   int64
+}
+
+// digestFile computes the SHA-256 and SHA-512 digests of the file at the given path in a single pass
+func digestFile(path string) (*fileDigests, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sha256Hash, sha512Hash := sha256.New(), sha512.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, sha512Hash), file)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDigests{sha256: sha256Hash.Sum(nil), sha512: sha512Hash.Sum(nil), size: size}, nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
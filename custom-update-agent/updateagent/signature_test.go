@@ -0,0 +1,395 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publicKeyDER(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func newECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, key crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifyWithTrustedKeys(t *testing.T) {
+	content := []byte(testContent)
+	digest := sha256.Sum256(content)
+	sha512Digest := sha512.Sum512(content)
+
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	ecdsaKey := newECDSAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   crypto.Signer
+		sign  func() ([]byte, error)
+		valid bool
+	}{
+		{
+			name:  "ed25519",
+			key:   ed25519Key,
+			sign:  func() ([]byte, error) { return ed25519.Sign(ed25519Key, content), nil },
+			valid: true,
+		},
+		{
+			name: "ed25519ph",
+			key:  ed25519Key,
+			sign: func() ([]byte, error) {
+				return ed25519Key.Sign(nil, sha512Digest[:], &ed25519.Options{Hash: crypto.SHA512})
+			},
+			valid: true,
+		},
+		{
+			name:  "ecdsa",
+			key:   ecdsaKey,
+			sign:  func() ([]byte, error) { return ecdsa.SignASN1(rand.Reader, ecdsaKey, digest[:]) },
+			valid: true,
+		},
+		{
+			name:  "rsa pkcs1v15",
+			key:   rsaKey,
+			sign:  func() ([]byte, error) { return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:]) },
+			valid: true,
+		},
+		{
+			name:  "rsa pss",
+			key:   rsaKey,
+			sign:  func() ([]byte, error) { return rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil) },
+			valid: true,
+		},
+		{
+			name: "other content",
+			key:  ecdsaKey,
+			sign: func() ([]byte, error) {
+				other := sha256.Sum256([]byte("other"))
+				return ecdsa.SignASN1(rand.Reader, ecdsaKey, other[:])
+			},
+		},
+		{
+			name: "untrusted key",
+			key:  ecdsaKey,
+			sign: func() ([]byte, error) { return ecdsa.SignASN1(rand.Reader, newECDSAKey(t), digest[:]) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePEM(t, filepath.Join(dir, "trust", "key.pem"), "PUBLIC KEY", publicKeyDER(t, test.key.Public()))
+			store, err := loadTrustStore(filepath.Join(dir, "trust"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "app.bin")
+			if err = os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			value, err := test.sign()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := parseSignature([]byte(base64.StdEncoding.EncodeToString(value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = store.verify(sig, path); test.valid && err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected an invalid signature")
+			}
+		})
+	}
+}
+
+func TestVerifyPureEd25519LargeFile(t *testing.T) {
+	dir := t.TempDir()
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	writePEM(t, filepath.Join(dir, "trust", "key.pem"), "PUBLIC KEY", publicKeyDER(t, public))
+	store, err := loadTrustStore(filepath.Join(dir, "trust"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, ed25519MaxPureBytes+1)
+	path := filepath.Join(dir, "app.bin")
+	if err = os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.verify(&signature{value: ed25519.Sign(private, content)}, path); err == nil {
+		t.Error("expected a pure Ed25519 signature of a large file to be rejected")
+	}
+	digest := sha512.Sum512(content)
+	value, err := private.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.verify(&signature{value: value}, path); err != nil {
+		t.Errorf("expected a valid Ed25519ph signature, got %v", err)
+	}
+}
+
+// testSigner signs files with certificates issued by a test root and records the signatures in a test transparency log
+type testSigner struct {
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	tlogKey  *ecdsa.PrivateKey
+	tlogID   string
+	trustDir string
+}
+
+func newTestSigner(t *testing.T, trustTlog bool) *testSigner {
+	signer := &testSigner{rootKey: newECDSAKey(t), tlogKey: newECDSAKey(t), trustDir: t.TempDir()}
+	signer.root = newCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, signer.rootKey.Public(), signer.rootKey)
+	tlogDER := publicKeyDER(t, signer.tlogKey.Public())
+	id := sha256.Sum256(tlogDER)
+	signer.tlogID = hex.EncodeToString(id[:])
+
+	writePEM(t, filepath.Join(signer.trustDir, "root.pem"), "CERTIFICATE", signer.root.Raw)
+	if trustTlog {
+		writePEM(t, filepath.Join(signer.trustDir, tlogDirectory, "rekor.pem"), "PUBLIC KEY", tlogDER)
+	}
+	return signer
+}
+
+func (s *testSigner) issue(t *testing.T, key *ecdsa.PrivateKey, notBefore time.Time, usages ...x509.ExtKeyUsage) *x509.Certificate {
+	return newCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(10 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}, s.root, key.Public(), s.rootKey)
+}
+
+// entry creates a signed transparency log entry of a hashedrekord with the given signature and file digest
+func (s *testSigner) entry(t *testing.T, value []byte, digest []byte, integratedTime int64) (string, []byte) {
+	body := base64.StdEncoding.EncodeToString([]byte(`{"apiVersion":"0.0.1","kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"` +
+		hex.EncodeToString(digest) + `"}},"signature":{"content":"` + base64.StdEncoding.EncodeToString(value) + `"}}}`))
+	payload := `{"body":"` + body + `","integratedTime":` + strconv.FormatInt(integratedTime, 10) + `,"logID":"` + s.tlogID + `","logIndex":7}`
+	payloadDigest := sha256.Sum256([]byte(payload))
+	set, err := ecdsa.SignASN1(rand.Reader, s.tlogKey, payloadDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, set
+}
+
+func TestVerifyCosignBundle(t *testing.T) {
+	content := []byte(testContent)
+	digest := sha256.Sum256(content)
+	otherDigest := sha256.Sum256([]byte("other"))
+	now := time.Now()
+	past := now.Add(-24 * time.Hour)
+
+	type bundleParams struct {
+		notBefore      time.Time
+		usages         []x509.ExtKeyUsage
+		entryDigest    []byte
+		integratedTime int64
+		signedTime     int64
+		sigstore       bool
+	}
+	valid := func(modify func(*bundleParams)) bundleParams {
+		params := bundleParams{
+			notBefore:      past,
+			usages:         []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			entryDigest:    digest[:],
+			integratedTime: past.Add(time.Minute).Unix(),
+			signedTime:     past.Add(time.Minute).Unix(),
+		}
+		if modify != nil {
+			modify(&params)
+		}
+		return params
+	}
+	tests := []struct {
+		name      string
+		trustTlog bool
+		noEntry   bool
+		params    bundleParams
+		err       string
+	}{
+		{name: "verified at the entry time", trustTlog: true, params: valid(nil)},
+		{name: "sigstore bundle verified at the entry time", trustTlog: true, params: valid(func(p *bundleParams) { p.sigstore = true })},
+		{name: "expired certificate without trusted log", params: valid(nil), err: "untrusted signing certificate"},
+		{name: "expired certificate without entry", trustTlog: true, noEntry: true, params: valid(nil), err: "untrusted signing certificate"},
+		{name: "valid certificate without entry", trustTlog: true, noEntry: true, params: valid(func(p *bundleParams) { p.notBefore = now.Add(-time.Minute) })},
+		{
+			name:      "entry time outside of the certificate validity",
+			trustTlog: true,
+			params:    valid(func(p *bundleParams) { p.integratedTime = now.Unix(); p.signedTime = now.Unix() }),
+			err:       "untrusted signing certificate",
+		},
+		{
+			name:      "forged entry time",
+			trustTlog: true,
+			params:    valid(func(p *bundleParams) { p.signedTime = now.Unix() }),
+			err:       "invalid signed entry timestamp",
+		},
+		{
+			name:      "entry of another file",
+			trustTlog: true,
+			params:    valid(func(p *bundleParams) { p.entryDigest = otherDigest[:] }),
+			err:       "another file digest",
+		},
+		{
+			name:      "certificate without code signing usage",
+			trustTlog: true,
+			params:    valid(func(p *bundleParams) { p.usages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth} }),
+			err:       "untrusted signing certificate",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer := newTestSigner(t, test.trustTlog)
+			key := newECDSAKey(t)
+			cert := signer.issue(t, key, test.params.notBefore, test.params.usages...)
+			value, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, set := signer.entry(t, value, test.params.entryDigest, test.params.signedTime)
+
+			var bundle map[string]any
+			if test.params.sigstore {
+				material := map[string]any{"certificate": map[string]any{"rawBytes": base64.StdEncoding.EncodeToString(cert.Raw)}}
+				if !test.noEntry {
+					keyID, _ := hex.DecodeString(signer.tlogID)
+					material["tlogEntries"] = []any{map[string]any{
+						"logIndex":          "7",
+						"logId":             map[string]any{"keyId": base64.StdEncoding.EncodeToString(keyID)},
+						"integratedTime":    strconv.FormatInt(test.params.integratedTime, 10),
+						"inclusionPromise":  map[string]any{"signedEntryTimestamp": base64.StdEncoding.EncodeToString(set)},
+						"canonicalizedBody": body,
+					}}
+				}
+				bundle = map[string]any{
+					"messageSignature": map[string]any{
+						"messageDigest": map[string]any{"algorithm": "SHA2_256", "digest": base64.StdEncoding.EncodeToString(digest[:])},
+						"signature":     base64.StdEncoding.EncodeToString(value),
+					},
+					"verificationMaterial": material,
+				}
+			} else {
+				bundle = map[string]any{
+					"base64Signature": base64.StdEncoding.EncodeToString(value),
+					"cert":            base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+				}
+				if !test.noEntry {
+					bundle["rekorBundle"] = map[string]any{
+						"SignedEntryTimestamp": base64.StdEncoding.EncodeToString(set),
+						"Payload": map[string]any{
+							"body":           body,
+							"integratedTime": test.params.integratedTime,
+							"logIndex":       7,
+							"logID":          signer.tlogID,
+						},
+					}
+				}
+			}
+			encoded, err := json.Marshal(bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := loadTrustStore(signer.trustDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := parseSignature(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "app.bin")
+			if err = os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err = store.verify(sig, path)
+			if test.err == "" && err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
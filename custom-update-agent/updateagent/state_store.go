@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+	"github.com/eclipse-kanto/update-manager/api/types"
+
+	"github.com/rickar/props"
+)
+
+const (
+	stateFileName       = "state.json"
+	stateVersion        = 1
+	legacyStateFileName = "state.props"
+	extractToKeyPrefix  = "/extract_to/"
+)
+
+// fileState is the recorded state of the managed files of a generation
+type fileState struct {
+	Version int              `json:"version"`
+	Files   []*installedFile `json:"files"`
+	// DesiredState is the desired state applied by the activation of the generation, used for reconciliation
+	DesiredState *types.DesiredState `json:"desired_state,omitempty"`
+}
+
+// installedFile is the record of a single managed file
+type installedFile struct {
+	util.File
+	// InstalledSize is the size of the installed file content in bytes, for archives the total size of the extracted files
+	InstalledSize int64 `json:"installed_size,omitempty"`
+	// InstalledAt is the time the file was activated
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+	// ActivityID is the ID of the update operation that installed the file
+	ActivityID string `json:"activity_id,omitempty"`
+}
+
+// readState reads the state of the managed files in the given generation directory.
+// A state.props file written by earlier versions of the agent is migrated, and the state of a directory without any state file
+// is initialized from its entries with unknown download URLs.
+func readState(directory string) (*fileState, error) {
+	data, err := os.ReadFile(filepath.Join(directory, stateFileName))
+	if err == nil {
+		state := &fileState{}
+		if err = json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("cannot parse state file: %w", err)
+		}
+		if state.Version > stateVersion {
+			return nil, fmt.Errorf("unsupported state file version %d", state.Version)
+		}
+		return state, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	state, err := readLegacyState(directory)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeState(directory, state); err != nil {
+		return nil, err
+	}
+	if err = os.Remove(filepath.Join(directory, legacyStateFileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return state, nil
+}
+
+// readLegacyState converts the state.props file, if any, or the entries of the given directory into a state
+func readLegacyState(directory string) (*fileState, error) {
+	state := &fileState{Version: stateVersion}
+	propsFile, err := os.Open(filepath.Join(directory, legacyStateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug(fmt.Sprintf("initializing state of directory [%s] from its %d entries", directory, len(entries)))
+		for _, entry := range entries {
+			state.Files = append(state.Files, &installedFile{File: util.File{Name: entry.Name(), DownloadURL: "unknown"}})
+		}
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer propsFile.Close()
+
+	properties, err := props.Read(propsFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s file: %w", legacyStateFileName, err)
+	}
+	slog.Debug(fmt.Sprintf("migrating %s file in directory [%s]", legacyStateFileName, directory))
+	for _, key := range properties.Names() {
+		// the extraction directories of archives are stored under keys with the extractToKeyPrefix prefix, which cannot clash with file names
+		if strings.HasPrefix(key, extractToKeyPrefix) {
+			continue
+		}
+		url, _ := properties.Get(key)
+		file := &installedFile{File: util.File{Name: key, DownloadURL: url}}
+		if extractTo, ok := properties.Get(extractToKeyPrefix + key); ok {
+			file.Type = util.FileTypeArchive
+			file.ExtractTo = extractTo
+		}
+		state.Files = append(state.Files, file)
+	}
+	return state, nil
+}
+
+// writeState atomically replaces the state file in the given generation directory
+func writeState(directory string, state *fileState) error {
+	state.Version = stateVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(directory, stateFileName), data)
+}
+
+// files returns the recorded files
+func (s *fileState) files() []*util.File {
+	files := make([]*util.File, len(s.Files))
+	for i, file := range s.Files {
+		files[i] = &file.File
+	}
+	return files
+}
+
+// find returns the record of the file with the given name, or nil if there is no such file
+func (s *fileState) find(name string) *installedFile {
+	for _, file := range s.Files {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
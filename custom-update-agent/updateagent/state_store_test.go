@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
+)
+
+func TestReadState(t *testing.T) {
+	sha256Sum := sha256.Sum256([]byte("a"))
+	digest := hex.EncodeToString(sha256Sum[:])
+	tests := []struct {
+		name    string
+		entries map[string]string
+		files   []util.File
+		err     string
+	}{
+		{name: "empty directory"},
+		{
+			name:    "directory without state",
+			entries: map[string]string{"a.bin": "a", "b.bin": "b"},
+			files:   []util.File{{Name: "a.bin", DownloadURL: "unknown"}, {Name: "b.bin", DownloadURL: "unknown"}},
+		},
+		{
+			name: "state file",
+			entries: map[string]string{
+				stateFileName: `{"version":1,"files":[{"file_name":"a.bin","download_url":"https://example.com/a.bin","sha256":"` + digest + `"}]}`,
+				"a.bin":       "a",
+			},
+			files: []util.File{{Name: "a.bin", DownloadURL: "https://example.com/a.bin", SHA256: digest}},
+		},
+		{
+			name: "legacy state file",
+			entries: map[string]string{
+				legacyStateFileName: "a.bin=https://example.com/a.bin\napp.tar=https://example.com/app.tar\n" + extractToKeyPrefix + "app.tar=app/lib\n",
+				"a.bin":             "a",
+			},
+			files: []util.File{
+				{Name: "a.bin", DownloadURL: "https://example.com/a.bin"},
+				{Name: "app.tar", DownloadURL: "https://example.com/app.tar", Type: util.FileTypeArchive, ExtractTo: "app/lib"},
+			},
+		},
+		{name: "corrupt state file", entries: map[string]string{stateFileName: "{"}, err: "cannot parse state file"},
+		{name: "newer state file", entries: map[string]string{stateFileName: `{"version":2}`}, err: "unsupported state file version 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			for name, content := range test.entries {
+				if err := os.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			state, err := readState(directory)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStateFiles(t, state, test.files)
+
+			// the state is persisted in the current format and read back unchanged
+			if _, err := os.Stat(filepath.Join(directory, legacyStateFileName)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected the legacy state file to be removed, got %v", err)
+			}
+			if state, err = readState(directory); err != nil {
+				t.Fatal(err)
+			}
+			if state.Version != stateVersion {
+				t.Errorf("expected state version %d, got %d", stateVersion, state.Version)
+			}
+			checkStateFiles(t, state, test.files)
+		})
+	}
+}
+
+func checkStateFiles(t *testing.T, state *fileState, expected []util.File) {
+	t.Helper()
+	if len(state.Files) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(state.Files))
+	}
+	for _, file := range expected {
+		recorded := state.find(file.Name)
+		if recorded == nil {
+			t.Errorf("expected file [%s] to be recorded", file.Name)
+			continue
+		}
+		if recorded.DownloadURL != file.DownloadURL || recorded.SHA256 != file.SHA256 || recorded.Type != file.Type || recorded.ExtractTo != file.ExtractTo {
+			t.Errorf("expected file %+v, got %+v", file, recorded.File)
+		}
+	}
+}
+
+func TestWriteState(t *testing.T) {
+	directory := t.TempDir()
+	state := &fileState{Files: []*installedFile{{File: util.File{Name: "a.bin", DownloadURL: "https://example.com/a.bin"}, ActivityID: "activity"}}}
+	if err := writeState(directory, state); err != nil {
+		t.Fatal(err)
+	}
+	// a stale temporary file of an interrupted write is replaced
+	if err := os.WriteFile(filepath.Join(directory, stateFileName+".tmp"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state.Files = append(state.Files, &installedFile{File: util.File{Name: "b.bin", DownloadURL: "https://example.com/b.bin"}})
+	if err := writeState(directory, state); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readState(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStateFiles(t, read, []util.File{{Name: "a.bin", DownloadURL: "https://example.com/a.bin"}, {Name: "b.bin", DownloadURL: "https://example.com/b.bin"}})
+	if activityID := read.find("a.bin").ActivityID; activityID != "activity" {
+		t.Errorf("expected activity ID [activity], got [%s]", activityID)
+	}
+	if _, err := os.Stat(filepath.Join(directory, stateFileName+".tmp")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no temporary state file, got %v", err)
+	}
+}
@@ -13,24 +13,52 @@
 package updateagent
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/eclipse-kanto/update-manager/api"
 	"github.com/eclipse-kanto/update-manager/api/agent"
 	"github.com/eclipse-kanto/update-manager/mqtt"
 )
 
-// newUpdateManager instantiates a new update manager instance
-func newUpdateManager(domainName string) api.UpdateManager {
+// newUpdateManager instantiates a new update manager instance for the given domain
+func newUpdateManager(domain *DomainConfig) api.UpdateManager {
+	ctx, stop := context.WithCancelCause(context.Background())
 	return &fileUpdateManager{
-		domainName:            domainName,
+		domainName:            domain.Name,
+		config:                domain,
+		directory:             filesDirectory(domain.Directory),
 		createUpdateOperation: newOperation,
+		ctx:                   ctx,
+		stop:                  stop,
 	}
 }
 
-// Init initializes a new Update Agent instance using given configuration and domain
+// Init initializes a new Update Agent instance using given configuration and domain, which manages the files in FileDirectory
 func Init(config *mqtt.ConnectionConfig, domainName string) (interface{}, error) {
-	mqttClient, err := mqtt.NewUpdateAgentClient(domainName, config)
+	return InitDomain(config, NewDomainConfig(domainName, FileDirectory))
+}
+
+// InitDomain initializes a new Update Agent instance for the given domain.
+// The same connection configuration can be used for all domains managed by the agent process.
+func InitDomain(config *mqtt.ConnectionConfig, domain *DomainConfig) (interface{}, error) {
+	if err := domain.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := parseDownloadWindows(DownloadWindows); err != nil {
+		return nil, fmt.Errorf("invalid download windows: %w", err)
+	}
+	directory := filesDirectory(domain.Directory)
+	if err := directory.initGenerations(); err != nil {
+		return nil, fmt.Errorf("cannot prepare files directory of domain %s: %w", domain.Name, err)
+	}
+	// a state.props file of earlier agent versions is migrated on the first start
+	if _, err := readState(directory.currentDirectory()); err != nil {
+		return nil, fmt.Errorf("cannot read state of files directory of domain %s: %w", domain.Name, err)
+	}
+	mqttClient, err := mqtt.NewUpdateAgentClient(domain.Name, config)
 	if err != nil {
 		return nil, err
 	}
-	return agent.NewUpdateAgent(mqttClient, newUpdateManager(domainName)), nil
+	return agent.NewUpdateAgent(mqttClient, newUpdateManager(domain)), nil
 }
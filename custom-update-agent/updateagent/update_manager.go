@@ -17,15 +17,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
 
 	"github.com/eclipse-kanto/update-manager/api"
 	"github.com/eclipse-kanto/update-manager/api/types"
-
-	"github.com/rickar/props"
 )
 
 const (
@@ -33,18 +32,40 @@ const (
 	parameterDomain   = "domain"
 )
 
-// FileDirectory points to the directory managed by the Files Update Agent
+// FileDirectory points to the directory managed by the Files Update Agent, when a single domain is managed
 var FileDirectory = ""
 
+// DownloadConcurrency is the maximum number of files downloaded in parallel, it can be overridden per domain and per desired state
+var DownloadConcurrency = 4
+
 type fileUpdateManager struct {
 	domainName string
+	config     *DomainConfig
+	directory  filesDirectory
 
 	applyLock             sync.Mutex
 	eventCallback         api.UpdateManagerCallback
 	createUpdateOperation createUpdateOperation
 	operation             UpdateOperation
+	// operationInProgress is set from the identification of an operation with actions until its cleanup
+	operationInProgress atomic.Bool
+	stopWatch           context.CancelFunc
+	// downloadTimer resumes the download of the operation paused outside of the download windows
+	downloadTimer *time.Timer
+
+	// repairLock guards the cancellation of the repair in progress and the number of requests waiting for it
+	repairLock      sync.Mutex
+	cancelRepair    context.CancelFunc
+	pendingRequests int
+
+	// ctx is cancelled when the update manager is disposed, which stops the operation steps in progress, e.g. downloads
+	ctx  context.Context
+	stop context.CancelCauseFunc
 }
 
+// errAgentStopping is the cause of cancelling the operation steps in progress when the update manager is disposed
+var errAgentStopping = errors.New("update agent is stopping")
+
 // Name returns the name of this update manager, e.g. "files".
 func (updMgr *fileUpdateManager) Name() string {
 	return updMgr.domainName
@@ -55,7 +76,7 @@ func (updMgr *fileUpdateManager) Name() string {
 // If errors are detected, then IDENTIFICATION_FAILED feedback status is reported and operation finishes unsuccessfully.
 // Otherwise, IDENTIFIED feedback status with identified actions is reported and it will wait for further commands to proceed.
 func (updMgr *fileUpdateManager) Apply(ctx context.Context, activityID string, desiredState *types.DesiredState) {
-	updMgr.applyLock.Lock()
+	updMgr.lockForRequest()
 	defer updMgr.applyLock.Unlock()
 
 	slog.Debug("processing desired state - start")
@@ -70,7 +91,9 @@ func (updMgr *fileUpdateManager) Apply(ctx context.Context, activityID string, d
 
 	// identification phase
 	newOperation.Feedback(types.StatusIdentifying, "", "")
-	hasActions, err := newOperation.Identify()
+	identifyCtx, cancel := updMgr.operationContext(ctx)
+	defer cancel()
+	hasActions, err := newOperation.Identify(identifyCtx)
 	if err != nil {
 		newOperation.Feedback(types.StatusIdentificationFailed, err.Error(), "")
 		slog.Error("processing desired state - identification phase failed", "error", err)
@@ -80,9 +103,13 @@ func (updMgr *fileUpdateManager) Apply(ctx context.Context, activityID string, d
 	if !hasActions {
 		slog.Debug("processing desired state - identification phase completed, no actions identified, sending COMPLETE status")
 		newOperation.Feedback(types.StatusCompleted, "", "")
+		updMgr.removeStaleDownloads()
 		return
 	}
+	updMgr.stopScheduledDownload()
 	updMgr.operation = newOperation
+	updMgr.operationInProgress.Store(true)
+	updMgr.removeStaleDownloads()
 	slog.Debug("processing desired state - identification phase completed, waiting for commands...")
 }
 
@@ -92,7 +119,7 @@ func (updMgr *fileUpdateManager) Command(ctx context.Context, activityID string,
 		slog.Warn(fmt.Sprintf("Skipping received command for activityId %s, but no payload.", activityID))
 		return
 	}
-	updMgr.applyLock.Lock()
+	updMgr.lockForRequest()
 	defer updMgr.applyLock.Unlock()
 
 	operation := updMgr.operation
@@ -105,7 +132,12 @@ func (updMgr *fileUpdateManager) Command(ctx context.Context, activityID string,
 			command.Command, command.Baseline, activityID, operation.GetActivityID()))
 		return
 	}
-	operation.Execute(command.Command, command.Baseline)
+	commandCtx, cancel := updMgr.operationContext(ctx)
+	defer cancel()
+	operation.Execute(commandCtx, command.Command, command.Baseline)
+	if command.Command == types.CommandCleanup {
+		updMgr.operationInProgress.Store(false)
+	}
 }
 
 // Get returns the current state as an inventory graph.
@@ -152,53 +184,120 @@ func (updMgr *fileUpdateManager) asSoftwareNode() *types.SoftwareNode {
 }
 
 func (updMgr *fileUpdateManager) getCurrentFiles() []*types.SoftwareNode {
-	files := []*util.File{}
-	propsFilePath := FileDirectory + "/state.props"
-
-	_, err := os.Stat(propsFilePath)
-
-	if errors.Is(err, os.ErrNotExist) {
-		entries, err := os.ReadDir(FileDirectory)
-		if err != nil {
-			slog.Error("got error checking current files", "error", err)
-			return nil
-		}
-		_, err = os.Create(propsFilePath)
-		if err != nil {
-			slog.Error(fmt.Sprintf("got error creating file [%s]", "state.props"), "error", err)
-		}
-		for _, entry := range entries {
-			addProperty(entry.Name(), "unknown")
-		}
-	}
-	propsFile, err := os.Open(propsFilePath)
+	state, err := readState(updMgr.directory.currentDirectory())
 	if err != nil {
-		slog.Error("got error checking current files", "error", err)
+		slog.Error("got error reading state file", "error", err)
 		return nil
 	}
+	return util.FromFiles(state.files())
+}
 
-	properties, err := props.Read(propsFile)
-	if err != nil {
-		slog.Error("got error when reading state.props file", "error", err)
-		return nil
+// operationContext returns the context of an operation step, which is cancelled with the given context or when the update manager is disposed
+func (updMgr *fileUpdateManager) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	stepCtx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(updMgr.ctx, func() {
+		cancel(context.Cause(updMgr.ctx))
+	})
+	return stepCtx, func() {
+		stop()
+		cancel(context.Canceled)
 	}
+}
 
-	for _, filename := range properties.Names() {
-		url, _ := properties.Get(filename)
-		files = append(files, &util.File{Name: filename, DownloadURL: url})
+// lockForRequest acquires the apply lock for a request of the Update Manager.
+// A repair of the files in progress is cancelled first, so that the request does not wait for it to complete.
+func (updMgr *fileUpdateManager) lockForRequest() {
+	updMgr.repairLock.Lock()
+	updMgr.pendingRequests++
+	if updMgr.cancelRepair != nil {
+		updMgr.cancelRepair()
 	}
+	updMgr.repairLock.Unlock()
+
+	updMgr.applyLock.Lock()
 
-	return util.FromFiles(files)
+	updMgr.repairLock.Lock()
+	updMgr.pendingRequests--
+	updMgr.repairLock.Unlock()
 }
 
-// Dispose releases all resources used by this instance
+// Dispose releases all resources used by this instance.
+// The operation steps in progress are cancelled first, so that their failure is reported before the update agent disconnects.
 func (updMgr *fileUpdateManager) Dispose() error {
+	updMgr.stop(errAgentStopping)
+	updMgr.applyLock.Lock()
+	defer updMgr.applyLock.Unlock()
+
+	updMgr.stopScheduledDownload()
+	if updMgr.stopWatch != nil {
+		updMgr.stopWatch()
+		updMgr.stopWatch = nil
+	}
 	return nil
 }
 
-// WatchEvents subscribes for events that update the current state inventory
+// WatchEvents subscribes for events that update the current state inventory.
+// An operation interrupted by an agent restart is recovered first, as the feedback callback is available at this point.
+// Then, the managed files are watched and the current state is reported when they are changed outside an update operation.
+// If enabled, the managed files are also periodically reconciled with the last applied desired state.
+// When the given context is done, e.g. the agent received a stop signal, the operation steps in progress are cancelled.
 func (updMgr *fileUpdateManager) WatchEvents(ctx context.Context) {
-	// no events handled yet - current state inventory reported only on initial start or explicit get request
+	context.AfterFunc(ctx, func() {
+		updMgr.stop(errAgentStopping)
+	})
+	updMgr.recoverOperation()
+
+	updMgr.applyLock.Lock()
+	defer updMgr.applyLock.Unlock()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	updMgr.stopWatch = cancel
+	go updMgr.watchFiles(watchCtx)
+	if updMgr.config.ReconcileInterval > 0 {
+		go updMgr.reconcileFiles(watchCtx)
+	}
+}
+
+// recoverOperation reloads the operation interrupted by an agent restart from its journal, if any, and resumes or rolls it back
+func (updMgr *fileUpdateManager) recoverOperation() {
+	updMgr.applyLock.Lock()
+	defer updMgr.applyLock.Unlock()
+
+	j, err := updMgr.directory.loadJournal()
+	if err != nil {
+		slog.Error("got error loading operation journal", "error", err)
+		return
+	}
+	if j == nil {
+		return
+	}
+	internalDesiredState, err := toInternalDesiredState(j.DesiredState, updMgr.domainName)
+	if err != nil {
+		slog.Error("could not parse desired state of the interrupted operation, discarding it", "error", err)
+		updMgr.directory.removeJournal()
+		return
+	}
+	operation := updMgr.createUpdateOperation(updMgr, j.ActivityID, internalDesiredState)
+	recoverCtx, cancel := updMgr.operationContext(context.Background())
+	defer cancel()
+	inProgress, err := operation.Recover(recoverCtx, j)
+	if err != nil {
+		slog.Error(fmt.Sprintf("could not recover operation for activityId %s", j.ActivityID), "error", err)
+		return
+	}
+	if inProgress {
+		updMgr.operation = operation
+		updMgr.operationInProgress.Store(true)
+	}
+}
+
+// removeStaleDownloads removes the temporary directories of all operations except the one in progress
+func (updMgr *fileUpdateManager) removeStaleDownloads() {
+	activityID := ""
+	if updMgr.operation != nil && updMgr.operationInProgress.Load() {
+		activityID = updMgr.operation.GetActivityID()
+	}
+	updMgr.directory.removeStaleDownloads(activityID)
 }
 
 // SetCallback sets the callback instance that is used for desired state feedback / current state notifications.
@@ -0,0 +1,274 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+type feedbackEvent struct {
+	baseline string
+	status   types.StatusType
+	message  string
+	actions  []types.Action
+}
+
+// testCallback records the desired state feedback and the current state events sent by the update manager
+type testCallback struct {
+	lock          sync.Mutex
+	feedback      []feedbackEvent
+	currentStates []*types.Inventory
+}
+
+func (c *testCallback) HandleDesiredStateFeedbackEvent(domain string, activityID string, baseline string, status types.StatusType, message string, actions []*types.Action) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	event := feedbackEvent{baseline: baseline, status: status, message: message}
+	for _, action := range actions {
+		event.actions = append(event.actions, *action)
+	}
+	c.feedback = append(c.feedback, event)
+}
+
+func (c *testCallback) HandleCurrentStateEvent(domain string, activityID string, currentState *types.Inventory) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.currentStates = append(c.currentStates, currentState)
+}
+
+func (c *testCallback) currentStateEvents() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.currentStates)
+}
+
+func (c *testCallback) statuses() []types.StatusType {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var statuses []types.StatusType
+	for _, event := range c.feedback {
+		statuses = append(statuses, event.status)
+	}
+	return statuses
+}
+
+func (c *testCallback) last() feedbackEvent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.feedback) == 0 {
+		return feedbackEvent{}
+	}
+	return c.feedback[len(c.feedback)-1]
+}
+
+// newTestUpdateManager returns an update manager of the files domain managing a temporary directory
+func newTestUpdateManager(t *testing.T) (*fileUpdateManager, *testCallback) {
+	t.Helper()
+	directory := filepath.Join(t.TempDir(), "files")
+	updMgr := newUpdateManager(NewDomainConfig("files", directory)).(*fileUpdateManager)
+	if err := filesDirectory(directory).initGenerations(); err != nil {
+		t.Fatal(err)
+	}
+	callback := &testCallback{}
+	updMgr.SetCallback(callback)
+	t.Cleanup(func() { updMgr.Dispose() })
+	return updMgr, callback
+}
+
+// newTestServer serves the given files by their names
+func newTestServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		content, ok := files[request.URL.Path[1:]]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newRecordingTestServer serves the given files by their names like newTestServer, and records the paths of the files downloaded by GET requests
+func newRecordingTestServer(t *testing.T, files map[string]string) (*httptest.Server, func(path string) bool) {
+	t.Helper()
+	handler := newTestServer(t, files).Config.Handler
+	var lock sync.Mutex
+	downloaded := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// the sizes of the files are requested with HEAD requests during identification
+		if request.Method == http.MethodGet {
+			lock.Lock()
+			downloaded[request.URL.Path] = true
+			lock.Unlock()
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	return server, func(path string) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return downloaded[path]
+	}
+}
+
+// newTestDesiredState returns a desired state of the files domain with a component per given file name and download URL
+func newTestDesiredState(files map[string]string, config ...*types.KeyValuePair) *types.DesiredState {
+	domain := &types.Domain{ID: "files", Config: config}
+	for name, url := range files {
+		domain.Components = append(domain.Components, &types.ComponentWithConfig{
+			Component: types.Component{ID: name, Version: "1"},
+			Config: []*types.KeyValuePair{
+				{Key: "file_name", Value: name},
+				{Key: "download_url", Value: url},
+			},
+		})
+	}
+	return &types.DesiredState{Domains: []*types.Domain{domain}}
+}
+
+// withComponentConfig adds the given configuration to the component of the file with the given name
+func withComponentConfig(desiredState *types.DesiredState, name string, config ...*types.KeyValuePair) *types.DesiredState {
+	for _, component := range desiredState.Domains[0].Components {
+		if component.ID == name {
+			component.Config = append(component.Config, config...)
+		}
+	}
+	return desiredState
+}
+
+// installTestFiles applies the given desired state through all commands and fails the test if it is not applied successfully
+func installTestFiles(t *testing.T, updMgr *fileUpdateManager, callback *testCallback, activityID string, desiredState *types.DesiredState) {
+	t.Helper()
+	updMgr.Apply(context.Background(), activityID, desiredState)
+	for _, command := range []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup} {
+		updMgr.Command(context.Background(), activityID, &types.DesiredStateCommand{Command: command})
+	}
+	if status := callback.last().status; status != types.BaselineStatusCleanupSuccess {
+		t.Fatalf("expected last status %s, got %v with message %q", types.BaselineStatusCleanupSuccess, callback.statuses(), callback.last().message)
+	}
+}
+
+func TestUpdateManagerInstallsFiles(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+
+	updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt", "b.txt": server.URL + "/b.txt"}))
+	for _, command := range []types.CommandType{types.CommandDownload, types.CommandUpdate, types.CommandActivate, types.CommandCleanup} {
+		updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: command})
+	}
+
+	if status := callback.last().status; status != types.BaselineStatusCleanupSuccess {
+		t.Fatalf("expected last status %s, got %s in %v", types.BaselineStatusCleanupSuccess, status, callback.statuses())
+	}
+	for name, content := range map[string]string{"a.txt": "a", "b.txt": "b"} {
+		data, err := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expected content %q of %s, got %q", content, name, data)
+		}
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	server := newTestServer(t, map[string]string{"a.txt": "a"})
+
+	updMgr.Apply(context.Background(), "activity", newTestDesiredState(map[string]string{"a.txt": server.URL + "/a.txt"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	updMgr.Command(ctx, "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+	statuses := callback.statuses()
+	for _, status := range statuses {
+		if status == types.BaselineStatusDownloadSuccess {
+			t.Fatalf("expected no %s status for a cancelled download, got %v", types.BaselineStatusDownloadSuccess, statuses)
+		}
+	}
+	if !hasStatus(statuses, types.BaselineStatusDownloadFailure) {
+		t.Fatalf("expected %s status for a cancelled download, got %v", types.BaselineStatusDownloadFailure, statuses)
+	}
+}
+
+func TestVersionChangeReplacesFile(t *testing.T) {
+	updMgr, callback := newTestUpdateManager(t)
+	// new versions are published under the same URL
+	var lock sync.Mutex
+	published := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		writer.Write([]byte(published))
+	}))
+	t.Cleanup(server.Close)
+	desiredState := func(version string) *types.DesiredState {
+		ds := newTestDesiredState(map[string]string{"a.txt": server.URL + "/latest/a.txt"})
+		ds.Domains[0].Components[0].Version = version
+		return ds
+	}
+	installedVersion := func() string {
+		inventory, err := updMgr.Get(context.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range inventory.SoftwareNodes {
+			if strings.HasSuffix(node.ID, ":a.txt") {
+				return node.Version
+			}
+		}
+		return ""
+	}
+
+	// the desired states are applied in order to the same directory
+	tests := []struct {
+		name      string
+		version   string
+		published string
+		content   string
+	}{
+		{name: "first version", version: "1", published: "1", content: "1"},
+		{name: "same version", version: "1", published: "2", content: "1"},
+		{name: "new version", version: "2", published: "2", content: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lock.Lock()
+			published = test.published
+			lock.Unlock()
+			installTestFiles(t, updMgr, callback, test.name, desiredState(test.version))
+
+			content, err := os.ReadFile(filepath.Join(updMgr.directory.currentDirectory(), "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != test.content {
+				t.Errorf("expected content %q, got %q", test.content, content)
+			}
+			if version := installedVersion(); version != test.version {
+				t.Errorf("expected installed version %s in the inventory, got %s", test.version, version)
+			}
+		})
+	}
+}
@@ -13,19 +13,21 @@
 package updateagent
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
-	"net/http"
 	"os"
-	"reflect"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/eclipse-kanto/example-applications/custom-update-agent/util"
 	"github.com/eclipse-kanto/update-manager/api/types"
-
-	"github.com/rickar/props"
 )
 
 type fileAction struct {
@@ -34,6 +36,8 @@ type fileAction struct {
 
 	feedbackAction *types.Action
 	actionType     util.ActionType
+	// size is the size of the content to be downloaded determined during identification, 0 if unknown
+	size int64
 }
 
 type action struct {
@@ -41,33 +45,56 @@ type action struct {
 	actions []*fileAction
 }
 
+// Action statuses reported while repairing locally modified files, so that repairs can be told apart from replacements
+const (
+	actionStatusRepairing     types.ActionStatusType = "REPAIRING"
+	actionStatusRepairSuccess types.ActionStatusType = "REPAIR_SUCCESS"
+	actionStatusRepairFailure types.ActionStatusType = "REPAIR_FAILURE"
+)
+
 type operation struct {
+	directory          filesDirectory
 	temporaryDirectory string
 	downloadDirectory  string
-	backupDirectory    string
+
+	// previousGeneration is the generation active when the operation started, generation is the one staged by the operation
+	previousGeneration int
+	generation         int
+	currentState       *fileState
 
 	updateManager *fileUpdateManager
 	activityID    string
 	desiredState  *internalDesiredState
+	client        *downloadClient
+	throttle      *downloadThrottle
+	// paused is set while the download waits for the next download window
+	paused bool
+
+	allActions   *action
+	feedbackLock sync.Mutex
 
-	allActions *action
+	// reconcile is set for operations started by the reconciler, they also remove unexpected files and send no feedback
+	reconcile bool
 }
 
 // UpdateOperation defines an interface for an update operation process
 type UpdateOperation interface {
 	GetActivityID() string
-	Identify() (bool, error)
-	Execute(command types.CommandType, baseline string)
+	Identify(ctx context.Context) (bool, error)
+	Execute(ctx context.Context, command types.CommandType, baseline string)
 	Feedback(status types.StatusType, message string, baseline string)
+	Recover(ctx context.Context, j *journal) (bool, error)
 }
 
 type createUpdateOperation func(*fileUpdateManager, string, *internalDesiredState) UpdateOperation
 
 func newOperation(updMgr *fileUpdateManager, activityID string, desiredState *internalDesiredState) UpdateOperation {
 	return &operation{
+		directory:     updMgr.directory,
 		updateManager: updMgr,
 		activityID:    activityID,
 		desiredState:  desiredState,
+		client:        newDownloadClient(updMgr.config.CredentialsDirectory, desiredState.httpOptions),
 	}
 }
 
@@ -77,78 +104,105 @@ func (o *operation) GetActivityID() string {
 }
 
 // Identify executes the IDENTIFYING phase, triggered with the full desired state for the domain
-func (o *operation) Identify() (bool, error) {
-	var err error
-	o.temporaryDirectory, err = os.MkdirTemp("", "file_agent")
-	if err != nil {
-		slog.Error("got error creating temporary directory", "error", err)
-		return false, err
-	}
-	o.downloadDirectory, err = os.MkdirTemp(o.temporaryDirectory, "file_agent_download")
-	if err != nil {
-		slog.Error("got error creating download directory", "error", err)
-		return false, err
-	}
-	o.backupDirectory, err = os.MkdirTemp(o.temporaryDirectory, "file_agent_backup")
+func (o *operation) Identify(ctx context.Context) (bool, error) {
+	err := o.prepareDirectories()
 	if err != nil {
-		slog.Error("got error creating backup directory", "error", err)
 		return false, err
 	}
-
-	files, err := os.ReadDir(FileDirectory)
-	for _, f := range files {
-		filename := f.Name()
-		o.copyFile(filename, FileDirectory, o.backupDirectory)
-	}
-	if err != nil {
-		slog.Error("got error reading files directory", "error", err)
+	// the active generation is never modified, so it serves as the backup of the operation
+	if o.previousGeneration, err = o.directory.currentGeneration(); err != nil {
+		slog.Error("got error reading current generation of files directory", "error", err)
 		return false, err
 	}
 
-	currentFiles := []*util.File{}
-	propsFile, err := os.Open(FileDirectory + "/state.props")
-	if err != nil {
-		slog.Error("got error opening state.props file", "error", err)
+	if o.currentState, err = readState(o.directory.generationDirectory(o.previousGeneration)); err != nil {
+		slog.Error("got error reading state file", "error", err)
 		return false, err
 	}
 
-	properties, err := props.Read(propsFile)
-	if err != nil {
-		slog.Error("got error reading state.props file", "error", err)
-		return false, err
-	}
-
-	for _, filename := range properties.Names() {
-		url, _ := properties.Get(filename)
-		currentFiles = append(currentFiles, &util.File{Name: filename, DownloadURL: url})
+	currentFilesMap := util.AsNamedMap(o.currentState.files())
+	if o.reconcile {
+		unexpected, err := unexpectedFiles(o.directory.generationDirectory(o.previousGeneration), o.currentState)
+		if err != nil {
+			slog.Error("got error reading files directory", "error", err)
+			return false, err
+		}
+		for _, file := range unexpected {
+			currentFilesMap[file.Name] = file
+		}
 	}
-
-	currentFilesMap := util.AsNamedMap(currentFiles)
 	allActions := []*fileAction{}
 
 	slog.Debug("checking desired vs current files")
 
+	matched := map[string]*util.File{}
 	for _, desired := range o.desiredState.files {
+		if _, err := o.client.httpConfig(desired); err != nil {
+			return false, err
+		}
 		filename := desired.Name
 		current := currentFilesMap[filename]
 		if current != nil {
 			delete(currentFilesMap, filename)
+			detectDrift(o.directory.generationDirectory(o.previousGeneration), current)
+			matched[filename] = current
+		}
+	}
+	// the remaining current files are renamed to new desired files with the same content, instead of removing and downloading them again
+	for _, desired := range o.desiredState.files {
+		if matched[desired.Name] == nil {
+			if current := o.findRenamedFile(currentFilesMap, desired); current != nil {
+				delete(currentFilesMap, current.Name)
+				matched[desired.Name] = current
+			}
 		}
-		allActions = append(allActions, o.newFileAction(current, desired))
+	}
+	for _, desired := range o.desiredState.files {
+		allActions = append(allActions, o.newFileAction(matched[desired.Name], desired))
 	}
 
+	// files no longer needed are removed first, so that their names can be taken by extracted archives
 	destroyActions := o.newRemoveActions(currentFilesMap)
-	allActions = append(allActions, destroyActions...)
+	allActions = append(destroyActions, allActions...)
+
+	if len(allActions) > 0 {
+		if err = o.checkDiskSpace(ctx, allActions); err != nil {
+			slog.Error("disk space check failed", "error", err)
+			return false, err
+		}
+	}
 
 	o.allActions = &action{
 		status:  types.StatusIdentified,
 		actions: allActions,
 	}
-	propsFile.Close()
-
+	if len(allActions) > 0 {
+		o.saveJournal(types.StatusIdentified)
+	} else {
+		o.directory.removeJournal()
+	}
 	return len(allActions) > 0, nil
 }
 
+// prepareDirectories creates the temporary directories of the operation in the downloads directory of the files directory.
+// They are derived from the activity ID, so that partial downloads survive agent restarts.
+func (o *operation) prepareDirectories() error {
+	o.temporaryDirectory = o.directory.operationDirectory(o.activityID)
+	o.downloadDirectory = filepath.Join(o.temporaryDirectory, "file_agent_download")
+	for _, directory := range []string{o.directory.downloadsDirectory(), o.temporaryDirectory, o.downloadDirectory} {
+		if err := makePrivateDirectory(directory); err != nil {
+			slog.Error("got error creating download directory", "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// needsDownload checks if the desired file of the action has to be downloaded
+func (a *fileAction) needsDownload() bool {
+	return a.actionType == util.ActionAdd || a.actionType == util.ActionReplace || a.actionType == util.ActionRepair
+}
+
 func (o *operation) newFileAction(current *util.File, desired *util.File) *fileAction {
 	actionType := util.DetermineUpdateAction(current, desired)
 	message := util.GetActionMessage(actionType)
@@ -161,7 +215,7 @@ func (o *operation) newFileAction(current *util.File, desired *util.File) *fileA
 		feedbackAction: &types.Action{
 			Component: &types.Component{
 				ID:      o.updateManager.domainName + ":" + desired.Name,
-				Version: o.desiredState.findComponent(desired.Name).Version,
+				Version: desired.Version,
 			},
 			Status:  types.ActionStatusIdentified,
 			Message: message,
@@ -170,6 +224,27 @@ func (o *operation) newFileAction(current *util.File, desired *util.File) *fileA
 	}
 }
 
+// findRenamedFile returns the current file, which differs from the desired file only by its name, or nil if there is no such file.
+// Locally modified files are not renamed.
+func (o *operation) findRenamedFile(currentFiles map[string]*util.File, desired *util.File) *util.File {
+	names := make([]string, 0, len(currentFiles))
+	for name := range currentFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current := currentFiles[name]
+		if util.DetermineUpdateAction(current, desired) != util.ActionRename {
+			continue
+		}
+		detectDrift(o.directory.generationDirectory(o.previousGeneration), current)
+		if current.Drift == "" {
+			return current
+		}
+	}
+	return nil
+}
+
 func (o *operation) newRemoveActions(toBeRemoved map[string]*util.File) []*fileAction {
 	removeActions := []*fileAction{}
 	message := util.GetActionMessage(util.ActionRemove)
@@ -180,7 +255,8 @@ func (o *operation) newRemoveActions(toBeRemoved map[string]*util.File) []*fileA
 			current: current,
 			feedbackAction: &types.Action{
 				Component: &types.Component{
-					ID: o.updateManager.domainName + ":" + current.Name,
+					ID:      o.updateManager.domainName + ":" + current.Name,
+					Version: current.Version,
 				},
 				Status:  types.ActionStatusIdentified,
 				Message: message,
@@ -192,15 +268,22 @@ func (o *operation) newRemoveActions(toBeRemoved map[string]*util.File) []*fileA
 }
 
 // Execute executes each COMMAND (download, update, activate, etc) phase, triggered per baseline or for all the identified actions
-func (o *operation) Execute(command types.CommandType, baseline string) {
+func (o *operation) Execute(ctx context.Context, command types.CommandType, baseline string) {
 	commandHandler, action := o.getCommandHandler(baseline, command)
 	if action == nil {
 		return
 	}
-	commandHandler(o, action)
+	// the phase is recorded before it starts, so that an interrupted phase can be detected after an agent restart
+	o.saveJournal(inProgressStatuses[command])
+	commandHandler(ctx, o, action)
+	if command == types.CommandCleanup {
+		o.directory.removeJournal()
+		return
+	}
+	o.saveJournal(action.status)
 }
 
-type commandHandler func(*operation, *action)
+type commandHandler func(context.Context, *operation, *action)
 
 var commandHandlers = map[types.CommandType]struct {
 	expectedBaselineStatus []types.StatusType
@@ -208,7 +291,7 @@ var commandHandlers = map[types.CommandType]struct {
 	commandHandler         commandHandler
 }{
 	types.CommandDownload: {
-		expectedBaselineStatus: []types.StatusType{types.StatusIdentified},
+		expectedBaselineStatus: []types.StatusType{types.StatusIdentified, types.BaselineStatusRollbackSuccess},
 		baselineFailureStatus:  types.BaselineStatusDownloadFailure,
 		commandHandler:         download,
 	},
@@ -246,66 +329,174 @@ func (o *operation) getCommandHandler(baseline string, command types.CommandType
 	return handler.commandHandler, o.allActions
 }
 
-// ActionAdd and ActionReplace: download file from defined url to temporary file directory.
-func download(o *operation, baselineAction *action) {
-	var lastAction *fileAction
+// ActionAdd, ActionReplace and ActionRepair: download file from defined url to temporary file directory.
+// Files are downloaded in parallel, the first failed download cancels all other downloads in progress.
+// Outside of the download windows, the download is paused and resumed when the next window opens, without blocking other commands meanwhile.
+func download(ctx context.Context, o *operation, baselineAction *action) {
 	var lastActionErr error
-	lastActionMessage := ""
+	var paused bool
 
 	slog.Debug("downloading - starting...")
 	defer func() {
+		if paused && lastActionErr == nil {
+			slog.Debug("downloading - paused until the next download window.")
+			o.paused = true
+			if !o.reconcile {
+				o.updateManager.scheduleDownload(o, o.throttle.windows.next(time.Now()))
+			}
+			return
+		}
 		if lastActionErr == nil {
-			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloadSuccess, lastAction, types.ActionStatusDownloadSuccess, lastActionMessage)
-
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloadSuccess, nil, "", "")
 		} else {
-			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloadFailure, lastAction, types.ActionStatusDownloadFailure, lastActionErr.Error())
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloadFailure, nil, "", "")
 			rollback(o, baselineAction)
 		}
 
 		slog.Debug("downloading - done.")
 	}()
 
-	actions := baselineAction.actions
-	for _, action := range actions {
-		if lastAction != nil {
-			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, lastAction, types.ActionStatusDownloadSuccess, lastActionMessage)
-		}
-		lastAction = action
+	if o.throttle, lastActionErr = o.newDownloadThrottle(); lastActionErr != nil {
+		slog.Error("invalid download throttling", "error", lastActionErr)
+		return
+	}
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	progress := newProgressTracker(o, baselineAction)
+	slots := make(chan struct{}, o.downloadConcurrency())
 
-		if action.actionType == util.ActionAdd || action.actionType == util.ActionReplace {
+	for _, action := range baselineAction.actions {
+		if !action.needsDownload() {
+			continue
+		}
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			// the remaining files are not downloaded, so the download fails even if no started download failed
+			errLock.Lock()
+			if lastActionErr == nil {
+				lastActionErr = fmt.Errorf("download cancelled: %w", context.Cause(ctx))
+			}
+			errLock.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(action *fileAction) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
 			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloading, action.feedbackAction.Message)
+			err := o.downloadFileWithRetry(ctx, baselineAction, action, progress.forAction(action))
+			if err == nil {
+				message := "New file added."
+				if action.actionType == util.ActionRepair {
+					message = "File downloaded again for repair."
+				}
+				o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloadSuccess, message)
+				return
+			}
+			if errors.Is(err, errDownloadWindowClosed) && ctx.Err() == nil {
+				errLock.Lock()
+				paused = true
+				errLock.Unlock()
+				o.pauseDownload(baselineAction, action)
+				return
+			}
 
-			if err := o.downloadFile(action.desired); err != nil {
-				lastActionErr = err
+			if parentCtx.Err() != nil {
+				err = fmt.Errorf("download cancelled: %w", context.Cause(parentCtx))
+			}
+			errLock.Lock()
+			defer errLock.Unlock()
+			if lastActionErr != nil {
+				// cancelled due to the failure of another download
+				o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloadFailure, "Download cancelled.")
 				return
 			}
-			lastActionMessage = "New file added."
-		} else {
-			lastAction = nil
+			lastActionErr = err
+			cancel()
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloadFailure, err.Error())
+		}(action)
+	}
+	wg.Wait()
+
+	if lastActionErr == nil && !paused {
+		lastActionErr = verifySignatures(ctx, o, baselineAction)
+	}
+}
+
+// ActionAdd, ActionReplace and ActionRepair: verify the signatures of the downloaded files before they are updated.
+// Unsigned files are accepted only if signatures are not required, while signed files must always have a valid signature.
+func verifySignatures(ctx context.Context, o *operation, baselineAction *action) error {
+	var store *trustStore
+	for _, action := range baselineAction.actions {
+		if !action.needsDownload() {
+			continue
+		}
+		err := func() error {
+			if !action.desired.IsSigned() {
+				if o.updateManager.config.RequireSignatures {
+					return fmt.Errorf("file [%s] is not signed, but signatures are required", action.desired.Name)
+				}
+				return nil
+			}
+			if store == nil {
+				var err error
+				if store, err = loadTrustStore(o.updateManager.config.TrustStore); err != nil {
+					return err
+				}
+			}
+			sig, err := o.loadSignature(ctx, action.desired)
+			if err != nil {
+				return err
+			}
+			if err = store.verify(sig, filepath.Join(o.downloadDirectory, action.desired.Name)); err != nil {
+				return err
+			}
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloadSuccess, "New file added, signature verified.")
+			return nil
+		}()
+		if err != nil {
+			// the downloaded content cannot be trusted, so it must not be reused by a retried download
+			os.Remove(filepath.Join(o.downloadDirectory, action.desired.Name))
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusDownloading, action, types.ActionStatusDownloadFailure, err.Error())
+			return err
 		}
 	}
+	return nil
 }
 
-// ActionAdd, ActionNone and ActionReplace: update the state.props file with the new file-dowload url pairs.
-func activate(o *operation, baselineAction *action) {
+func (o *operation) downloadConcurrency() int {
+	if o.desiredState.downloadConcurrency > 0 {
+		return o.desiredState.downloadConcurrency
+	}
+	if o.updateManager.config.DownloadConcurrency > 0 {
+		return o.updateManager.config.DownloadConcurrency
+	}
+	return 1
+}
+
+// ActionAdd, ActionNone, ActionRename, ActionReplace and ActionRepair: record the desired files in the state file of the staged generation
+// and atomically switch the current link to the staged generation.
+func activate(_ context.Context, o *operation, baselineAction *action) {
 	var lastAction *fileAction
 	var lastActionErr error
 
 	lastActionMessage := ""
-
-	propsFile, err := os.OpenFile(FileDirectory+"/state.props", os.O_RDWR, 0666)
-
-	if err != nil {
-		slog.Error("got error opening state.props file", "error", err)
-		return
-	}
-
-	propsFile.Truncate(0)
-	defer propsFile.Close()
+	state := &fileState{DesiredState: o.desiredState.desiredState}
 
 	slog.Debug("activating - starting...")
 
 	defer func() {
+		if lastActionErr == nil {
+			if lastActionErr = o.directory.switchGeneration(o.generation); lastActionErr != nil {
+				slog.Error("got error switching to the new generation of files directory", "error", lastActionErr)
+			}
+		}
 		if lastActionErr == nil {
 			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusActivationSuccess, lastAction, types.ActionStatusActivationSuccess, lastActionMessage)
 		} else {
@@ -323,56 +514,120 @@ func activate(o *operation, baselineAction *action) {
 		}
 
 		lastAction = action
-		if action.actionType == util.ActionAdd || action.actionType == util.ActionReplace || action.actionType == util.ActionNone {
+		if action.actionType != util.ActionRemove {
 			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusActivating, action, types.ActionStatusActivating, action.feedbackAction.Message)
-			lastActionMessage = "Desired file added to state.props file."
-			if err := addProperty(action.desired.Name, action.desired.DownloadURL); err != nil {
+			lastActionMessage = "Desired file recorded in state file."
+			record, err := o.newInstalledFile(action)
+			if err != nil {
 				lastActionErr = err
-				slog.Error("got error updating state.props file", "error", err)
+				slog.Error("got error recording desired file", "error", err)
 				return
 			}
+			state.Files = append(state.Files, record)
 		} else {
 			lastAction = nil
 		}
 	}
+	if lastActionErr = writeState(o.directory.generationDirectory(o.generation), state); lastActionErr != nil {
+		slog.Error("got error writing state file", "error", lastActionErr)
+	}
 }
 
-// ActionAdd, ActionReplace: move file from temporary directory to fileagent directory.
-func update(o *operation, baselineAction *action) {
+// newInstalledFile creates the state record of the desired file of the given action.
+// The record of an unchanged or renamed file is kept, while the content of an added, replaced or repaired file is recorded as installed in the staged generation.
+// Kept records without a digest, e.g. migrated from a state.props file, get the digest of their content in the staged generation.
+func (o *operation) newInstalledFile(action *fileAction) (*installedFile, error) {
+	var recorded *installedFile
+	if action.actionType == util.ActionNone || action.actionType == util.ActionRename {
+		recorded = o.currentState.find(action.current.Name)
+	}
+	var record installedFile
+	if recorded != nil {
+		record = *recorded
+	} else {
+		installedAt := time.Now().UTC()
+		record = installedFile{InstalledAt: &installedAt, ActivityID: o.activityID}
+	}
+	digest := record.Digest
+	record.File = *action.desired
+	if digest == "" {
+		var err error
+		if digest, record.InstalledSize, err = contentDigest(o.directory.generationDirectory(o.generation), action.desired); err != nil {
+			return nil, err
+		}
+	}
+	record.Digest = digest
+	return &record, nil
+}
+
+// ActionAdd, ActionReplace and ActionRepair: move file from temporary directory to a new generation of the fileagent directory,
+// staged as a copy of the current generation. ActionRename: rename the current file in the new generation. The current generation is not modified until activation.
+func update(_ context.Context, o *operation, baselineAction *action) {
 	var lastAction *fileAction
 	var lastActionErr error
 	lastActionMessage := ""
+	lastActionStatus := types.ActionStatusUpdateSuccess
 
 	slog.Debug("updating - starting...")
 	defer func() {
 		if lastActionErr == nil {
-			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdateSuccess, lastAction, types.ActionStatusUpdateSuccess, lastActionMessage)
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdateSuccess, lastAction, lastActionStatus, lastActionMessage)
 		} else {
 			slog.Debug("last action error")
 			slog.Debug(lastActionErr.Error())
-			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdateFailure, lastAction, types.ActionStatusUpdateFailure, lastActionErr.Error())
+			failureStatus := types.ActionStatusUpdateFailure
+			if lastAction != nil && lastAction.actionType == util.ActionRepair {
+				failureStatus = actionStatusRepairFailure
+			}
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdateFailure, lastAction, failureStatus, lastActionErr.Error())
 			rollback(o, baselineAction)
 		}
 
 		slog.Debug("updating - done.")
 	}()
 
+	stagingDirectory, err := o.stageGeneration()
+	if err != nil {
+		slog.Error("got error staging new generation of files directory", "error", err)
+		lastActionErr = err
+		return
+	}
+
 	actions := baselineAction.actions
 	for _, action := range actions {
 		if lastAction != nil {
-			lastAction.feedbackAction.Status = types.ActionStatusUpdateSuccess
+			lastAction.feedbackAction.Status = lastActionStatus
 			lastAction.feedbackAction.Message = lastActionMessage
 		}
 		lastAction = action
-		if action.actionType == util.ActionAdd || action.actionType == util.ActionReplace {
+		lastActionStatus = types.ActionStatusUpdateSuccess
+		if action.actionType == util.ActionRepair {
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdating, action, actionStatusRepairing, action.feedbackAction.Message)
+			if err := o.installFile(action, stagingDirectory); err != nil {
+				lastActionErr = err
+				return
+			}
+			lastActionStatus = actionStatusRepairSuccess
+			lastActionMessage = "Locally modified file restored in directory."
+		} else if action.actionType == util.ActionAdd || action.actionType == util.ActionReplace {
 			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdating, action, types.ActionStatusUpdating, action.feedbackAction.Message)
-			if err := o.copyFile(action.desired.Name, o.downloadDirectory, FileDirectory); err != nil {
+			if err := o.installFile(action, stagingDirectory); err != nil {
 				lastActionErr = err
 				return
 			}
 			lastActionMessage = "File added to directory."
+			if action.desired.IsArchive() {
+				lastActionMessage = "Archive extracted to directory."
+			}
+		} else if action.actionType == util.ActionRename {
+			o.updateBaselineActionStatus(baselineAction, types.BaselineStatusUpdating, action, types.ActionStatusUpdating, action.feedbackAction.Message)
+			if err := o.renameFile(action, stagingDirectory); err != nil {
+				lastActionErr = err
+				return
+			}
+			lastActionMessage = fmt.Sprintf("File renamed from %s.", action.current.Name)
 		} else if action.actionType == util.ActionRemove {
-			if err := o.removeFile(action.current); err != nil {
+			if err := o.removeFile(action.current, stagingDirectory); err != nil {
 				lastActionErr = err
 				return
 			}
@@ -383,7 +638,7 @@ func update(o *operation, baselineAction *action) {
 	}
 }
 
-// Restores fileagent directory backup and removes all files in temporary directory
+// Switches the current link back to the generation active before the operation and removes the staged generation
 func rollback(o *operation, baselineAction *action) {
 	var lastAction *fileAction
 	var lastActionMessage string
@@ -400,41 +655,39 @@ func rollback(o *operation, baselineAction *action) {
 		slog.Debug("rollback - done.")
 	}()
 
-	files, err := os.ReadDir(FileDirectory)
-	if err != nil {
-		slog.Error("got error reading directory", "error", err)
-		lastActionErr = err
+	if o.generation == 0 {
 		return
 	}
-	backupFiles, err := os.ReadDir(o.backupDirectory)
+	current, err := o.directory.currentGeneration()
 	if err != nil {
-		slog.Error("got error opening directory", "error", err)
+		slog.Error("got error reading current generation of files directory", "error", err)
 		lastActionErr = err
 		return
 	}
-	if !reflect.DeepEqual(files, backupFiles) {
-		for _, entry := range files {
-			err = os.Remove(FileDirectory + "/" + entry.Name())
-			if err != nil {
-				slog.Error("got error removing file", "error", err)
-				lastActionErr = err
-				return
-			}
-		}
-
-		for _, f := range files {
-			filename := f.Name()
-			o.copyFile(filename, o.backupDirectory, FileDirectory)
+	if current == o.generation {
+		if err = o.directory.switchGeneration(o.previousGeneration); err != nil {
+			slog.Error("got error switching back to the previous generation of files directory", "error", err)
+			lastActionErr = err
+			return
 		}
 	}
+	if err = os.RemoveAll(o.directory.generationDirectory(o.generation)); err != nil {
+		slog.Error("got error removing staged generation of files directory", "error", err)
+		lastActionErr = err
+		return
+	}
+	o.generation = 0
 }
 
-// ActionRemove: removes the old file from fileagent directory.
 // ActionAdd and ActionReplace: removes temporary download directory.
-func cleanup(o *operation, baselineAction *action) {
+// Generations older than the previous ones kept for the domain are removed.
+func cleanup(_ context.Context, o *operation, baselineAction *action) {
 	slog.Debug("cleanup - starting...")
 
 	o.cleanupTemporaryFolders()
+	if err := o.directory.pruneGenerations(o.updateManager.config.KeepGenerations); err != nil {
+		slog.Error("got error removing old generations of files directory", "error", err)
+	}
 	o.Feedback(types.BaselineStatusCleanupSuccess, "", "")
 
 	slog.Debug("cleanup - done.")
@@ -442,16 +695,27 @@ func cleanup(o *operation, baselineAction *action) {
 
 // Feedback sends desired state feedback responses, baseline parameter is optional
 func (o *operation) Feedback(status types.StatusType, message string, baseline string) {
+	o.feedbackLock.Lock()
+	defer o.feedbackLock.Unlock()
+
+	if o.reconcile {
+		// the Update Manager is not aware of reconcile operations
+		slog.Debug(fmt.Sprintf("reconcile operation %s status %s", o.activityID, status), "message", message)
+		return
+	}
 	o.updateManager.eventCallback.HandleDesiredStateFeedbackEvent(o.updateManager.domainName, o.activityID, baseline, status, message, o.toFeedbackActions())
 }
 
 func (o *operation) updateBaselineActionStatus(baseline *action, baselineStatus types.StatusType,
 	action *fileAction, actionStatus types.ActionStatusType, message string) {
+	o.feedbackLock.Lock()
 	if action != nil {
 		action.feedbackAction.Status = actionStatus
 		action.feedbackAction.Message = message
 	}
 	baseline.status = baselineStatus
+	o.feedbackLock.Unlock()
+
 	o.Feedback(baselineStatus, "", "")
 }
 
@@ -490,7 +754,7 @@ func (o *operation) copyFile(filename string, sourcePath string, destinationPath
 	defer destinationFile.Close()
 	_, err = io.Copy(destinationFile, sourceFile)
 	if err != nil {
-		slog.Error(fmt.Sprintf("got error copying file [%s] to [%s]", filename, FileDirectory), "error", err)
+		slog.Error(fmt.Sprintf("got error copying file [%s] to [%s]", filename, destinationPath), "error", err)
 		return err
 
 	}
@@ -505,55 +769,116 @@ func (o *operation) cleanupTemporaryFolders() error {
 	return err
 }
 
-func (o *operation) removeFile(desired *util.File) error {
-	err := os.Remove(FileDirectory + "/" + desired.Name)
+// stageGeneration creates a new generation as a copy of the generation active before the operation
+func (o *operation) stageGeneration() (string, error) {
+	if o.generation != 0 {
+		// left over by a previous update attempt of this operation
+		if err := os.RemoveAll(o.directory.generationDirectory(o.generation)); err != nil {
+			return "", err
+		}
+	}
+	generation, err := o.directory.nextGeneration()
 	if err != nil {
-		slog.Error(fmt.Sprintf("got error removing file [%s]", desired.Name), "error", err)
+		return "", err
 	}
-	return err
+	o.generation = generation
+	stagingDirectory := o.directory.generationDirectory(generation)
+	if err = os.Mkdir(stagingDirectory, 0755); err != nil {
+		return "", err
+	}
+	return stagingDirectory, copyTree(o.directory.generationDirectory(o.previousGeneration), stagingDirectory)
 }
 
-func (o *operation) downloadFile(desired *util.File) error {
-	resp, err := http.Get(desired.DownloadURL)
+func (o *operation) removeFile(desired *util.File, directory string) error {
+	var err error
+	if desired.IsArchive() {
+		err = os.RemoveAll(filepath.Join(directory, desired.ExtractTo))
+	} else if desired.Drift == util.DriftUnexpected {
+		// unexpected entries may also be directories
+		err = os.RemoveAll(filepath.Join(directory, desired.Name))
+	} else {
+		err = os.Remove(filepath.Join(directory, desired.Name))
+	}
 	if err != nil {
-		slog.Debug(fmt.Sprintf("could not download file from url [%s]", desired.DownloadURL), "error", err)
-		return err
+		slog.Error(fmt.Sprintf("got error removing file [%s]", desired.Name), "error", err)
 	}
-	defer resp.Body.Close()
-	out, err := os.Create(o.downloadDirectory + "/" + desired.Name)
+	return err
+}
 
+// renameFile renames the current file of the given action to the desired name in the given directory.
+// The extraction directory of a renamed archive is kept as it is.
+func (o *operation) renameFile(action *fileAction, directory string) error {
+	if action.desired.IsArchive() {
+		return nil
+	}
+	err := os.Rename(filepath.Join(directory, action.current.Name), filepath.Join(directory, action.desired.Name))
 	if err != nil {
-		slog.Debug(fmt.Sprintf("could not create file [%s]", desired.Name), "error", err)
-		return err
+		slog.Error(fmt.Sprintf("got error renaming file [%s] to [%s]", action.current.Name, action.desired.Name), "error", err)
 	}
-	defer out.Close()
+	return err
+}
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		slog.Debug(fmt.Sprintf("could not copy contents from [%s] to file [%s]", desired.DownloadURL, desired.Name), "error", err)
-		return err
+// installFile places the downloaded file of the given action in the given directory, archives are extracted.
+// The current file is removed first if it is an archive or it is replaced by an archive.
+func (o *operation) installFile(action *fileAction, directory string) error {
+	desired := action.desired
+	if action.current != nil && (action.current.IsArchive() || desired.IsArchive()) {
+		if err := o.removeFile(action.current, directory); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if desired.IsArchive() {
+		return extractArchive(filepath.Join(o.downloadDirectory, desired.Name), filepath.Join(directory, desired.ExtractTo))
 	}
+	return o.copyFile(desired.Name, o.downloadDirectory, directory)
+}
 
-	return nil
+// copyTree recursively copies the content of the source directory into the destination directory
+func copyTree(sourcePath string, destinationPath string) error {
+	return filepath.WalkDir(sourcePath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(sourcePath, path)
+		if err != nil || relativePath == "." {
+			return err
+		}
+		target := filepath.Join(destinationPath, relativePath)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			return copyRegularFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
 }
 
-func addProperty(key string, value string) error {
-	propsFilePath := FileDirectory + "/state.props"
-	propsFile, err := os.OpenFile(propsFilePath, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+func copyRegularFile(sourcePath string, destinationPath string, perm fs.FileMode) error {
+	source, err := os.Open(sourcePath)
 	if err != nil {
-		slog.Debug(fmt.Sprintf("could not open file [%s]", propsFilePath), "error", err)
 		return err
 	}
-	defer propsFile.Close()
-
-	w := bufio.NewWriter(propsFile)
-
-	newProperties := props.NewProperties()
-	newProperties.Set(key, value)
-	newProperties.Write(w)
-
-	err = w.Flush()
-	return err
+	defer source.Close()
+	destination, err := os.OpenFile(destinationPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
 }
 
 func asStatusString(what []types.StatusType) string {
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func TestDownloadConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      []*types.KeyValuePair
+		concurrency int
+	}{
+		{name: "default", concurrency: DownloadConcurrency},
+		{name: "desired state limit", config: []*types.KeyValuePair{{Key: domainConfigMaxConcurrentDownloads, Value: "2"}}, concurrency: 2},
+		{name: "sequential", config: []*types.KeyValuePair{{Key: domainConfigMaxConcurrentDownloads, Value: "1"}}, concurrency: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			active, maxActive := 0, 0
+			server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if request.Method != http.MethodGet {
+					return
+				}
+				lock.Lock()
+				active++
+				maxActive = max(maxActive, active)
+				lock.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				lock.Lock()
+				active--
+				lock.Unlock()
+				writer.Write([]byte(request.URL.Path))
+			}))
+			t.Cleanup(server.Close)
+
+			files := map[string]string{}
+			for i := 0; i < 8; i++ {
+				name := fmt.Sprintf("%d.txt", i)
+				files[name] = server.URL + "/" + name
+			}
+			updMgr, callback := newTestUpdateManager(t)
+			updMgr.Apply(context.Background(), "activity", newTestDesiredState(files, test.config...))
+			updMgr.Command(context.Background(), "activity", &types.DesiredStateCommand{Command: types.CommandDownload})
+
+			if status := callback.last().status; status != types.BaselineStatusDownloadSuccess {
+				t.Fatalf("expected status %s, got %v", types.BaselineStatusDownloadSuccess, callback.statuses())
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if maxActive > test.concurrency {
+				t.Errorf("expected at most %d parallel downloads, got %d", test.concurrency, maxActive)
+			}
+			if test.concurrency > 1 && maxActive < 2 {
+				t.Errorf("expected parallel downloads, got %d at a time", maxActive)
+			}
+		})
+	}
+}
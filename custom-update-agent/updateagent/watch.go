@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package updateagent
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WatchDebounce is the time to wait for further changes of the managed files before the current state is reported
+var WatchDebounce = 2 * time.Second
+
+// watchFiles reports the current state each time the managed files are changed outside an update operation.
+// Changes are debounced, and changes made while an update operation is in progress are reported once it is finished.
+func (updMgr *fileUpdateManager) watchFiles(ctx context.Context) {
+	changes, err := watchDirectory(ctx, updMgr.directory)
+	if err != nil {
+		slog.Warn("cannot watch files directory for changes, current state is reported only on request", "error", err)
+		return
+	}
+	slog.Debug("watching files directory for changes")
+
+	timer := time.NewTimer(WatchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(WatchDebounce)
+		case <-timer.C:
+			if updMgr.operationInProgress.Load() {
+				timer.Reset(WatchDebounce)
+				continue
+			}
+			slog.Debug("files directory changed, reporting current state")
+			updMgr.eventCallback.HandleCurrentStateEvent(updMgr.Name(), "", toInventory(updMgr.asSoftwareNode(), updMgr.getCurrentFiles()))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build linux
+
+package updateagent
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	rootWatchMask       = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_TO
+	generationWatchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+)
+
+// inotifyWatcher watches the current link in the files directory and the whole tree of the current generation
+type inotifyWatcher struct {
+	directory filesDirectory
+	fd        int
+	file      *os.File
+
+	root           int
+	generationRoot int
+	watches        map[int]string
+}
+
+// watchDirectory starts watching the managed files with inotify, a value is sent on the returned channel for each batch of changes.
+// The channel is closed once the context is done.
+func watchDirectory(ctx context.Context, directory filesDirectory) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize inotify: %w", err)
+	}
+	// the non-blocking descriptor is served by the runtime poller, so that closing the file interrupts a pending read
+	w := &inotifyWatcher{directory: directory, fd: fd, file: os.NewFile(uintptr(fd), "inotify"), watches: map[int]string{}}
+	if w.root, err = syscall.InotifyAddWatch(fd, string(directory), rootWatchMask); err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("cannot watch [%s]: %w", directory, err)
+	}
+	if err = w.watchGeneration(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		w.file.Close()
+	}()
+	go w.run(changes)
+	return changes, nil
+}
+
+func (w *inotifyWatcher) run(changes chan<- struct{}) {
+	defer close(changes)
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			slog.Debug("stopped watching files directory", "error", err)
+			return
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buffer[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+			if w.handle(int(event.Wd), event.Mask, name) {
+				changed = true
+			}
+		}
+		if changed {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// handle processes a single event and reports if it changed the managed files
+func (w *inotifyWatcher) handle(wd int, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.rewatchGeneration()
+		return true
+	}
+	if wd == w.root {
+		if name != currentLinkName {
+			return false
+		}
+		// the current link is replaced, e.g. on activation
+		w.rewatchGeneration()
+		return true
+	}
+	directory, ok := w.watches[wd]
+	if !ok || mask&syscall.IN_IGNORED != 0 {
+		return false
+	}
+	if wd == w.generationRoot && (name == stateFileName || name == stateFileName+".tmp" || name == legacyStateFileName) {
+		return false
+	}
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.watchTree(filepath.Join(directory, name)); err != nil {
+			slog.Warn("cannot watch new directory", "directory", name, "error", err)
+		}
+	}
+	return true
+}
+
+func (w *inotifyWatcher) rewatchGeneration() {
+	if err := w.watchGeneration(); err != nil {
+		slog.Warn("cannot watch current generation of files directory", "error", err)
+	}
+}
+
+// watchGeneration replaces all watches of the previous generation with watches of the generation the current link points to
+func (w *inotifyWatcher) watchGeneration() error {
+	for wd := range w.watches {
+		// fails for already deleted directories, whose watches are removed automatically
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.watches, wd)
+	}
+	generation, err := filepath.EvalSymlinks(w.directory.currentDirectory())
+	if err != nil {
+		return err
+	}
+	if err = w.watchTree(generation); err != nil {
+		return err
+	}
+	for wd, directory := range w.watches {
+		if directory == generation {
+			w.generationRoot = wd
+		}
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) watchTree(root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, generationWatchMask)
+		if err != nil {
+			return fmt.Errorf("cannot watch [%s]: %w", path, err)
+		}
+		w.watches[wd] = path
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build linux
+
+package updateagent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDirectory(t *testing.T) {
+	directory := filesDirectory(filepath.Join(t.TempDir(), "files"))
+	if err := directory.initGenerations(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := watchDirectory(ctx, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile := func(path string) func() error {
+		return func() error { return os.WriteFile(path, []byte("content"), 0644) }
+	}
+	current := directory.currentDirectory()
+	// the steps are run in order, each on the result of the previous ones
+	steps := []struct {
+		name    string
+		change  func() error
+		changed bool
+	}{
+		{name: "new file", change: writeFile(filepath.Join(current, "a.txt")), changed: true},
+		{name: "modified file", change: writeFile(filepath.Join(current, "a.txt")), changed: true},
+		{name: "new directory", change: func() error { return os.Mkdir(filepath.Join(current, "dir"), 0755) }, changed: true},
+		{name: "file in new directory", change: writeFile(filepath.Join(current, "dir", "b.txt")), changed: true},
+		{name: "state file", change: writeFile(filepath.Join(current, stateFileName))},
+		{name: "journal", change: writeFile(directory.journalPath())},
+		{name: "staged generation", change: func() error { return os.MkdirAll(directory.generationDirectory(2), 0755) }},
+		{name: "file in staged generation", change: writeFile(filepath.Join(directory.generationDirectory(2), "c.txt"))},
+		{name: "activated generation", change: func() error { return directory.switchGeneration(2) }, changed: true},
+		{name: "file in activated generation", change: writeFile(filepath.Join(directory.generationDirectory(2), "c.txt")), changed: true},
+		{name: "file in previous generation", change: writeFile(filepath.Join(directory.generationDirectory(1), "a.txt"))},
+		{name: "removed file", change: func() error { return os.Remove(filepath.Join(directory.generationDirectory(2), "c.txt")) }, changed: true},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		timeout := 200 * time.Millisecond
+		if step.changed {
+			timeout = 5 * time.Second
+		}
+		select {
+		case <-changes:
+			if !step.changed {
+				t.Errorf("%s: unexpected change", step.name)
+			}
+		case <-time.After(timeout):
+			if step.changed {
+				t.Errorf("%s: expected a change", step.name)
+			}
+		}
+		// further events of the same step are dropped
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case <-changes:
+		default:
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("expected the changes channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the changes channel to be closed")
+	}
+}
+
+func TestWatchFilesDebounced(t *testing.T) {
+	debounce := WatchDebounce
+	WatchDebounce = 100 * time.Millisecond
+	defer func() { WatchDebounce = debounce }()
+
+	updMgr, callback := newTestUpdateManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go updMgr.watchFiles(ctx)
+	// the watch is set up asynchronously
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(updMgr.directory.currentDirectory(), "a.txt"), []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	if events := callback.currentStateEvents(); events != 1 {
+		t.Errorf("expected a single current state event for a burst of changes, got %d", events)
+	}
+
+	// changes made during an operation are reported once it is finished
+	updMgr.operationInProgress.Store(true)
+	if err := os.WriteFile(filepath.Join(updMgr.directory.currentDirectory(), "b.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if events := callback.currentStateEvents(); events != 1 {
+		t.Errorf("expected no current state event during an operation, got %d", events-1)
+	}
+	updMgr.operationInProgress.Store(false)
+	time.Sleep(500 * time.Millisecond)
+	if events := callback.currentStateEvents(); events != 2 {
+		t.Errorf("expected the changes to be reported after the operation, got %d events", events-1)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+//go:build !linux
+
+package updateagent
+
+import (
+	"context"
+	"errors"
+)
+
+// watchDirectory is supported on Linux only
+func watchDirectory(ctx context.Context, directory filesDirectory) (<-chan struct{}, error) {
+	return nil, errors.New("watching the files directory is supported on Linux only")
+}
@@ -12,10 +12,74 @@
 
 package util
 
+// FileTypeArchive denotes a file that is extracted into a directory inside the managed directory
+const FileTypeArchive = "archive"
+
+// Compression formats of downloaded files, which are decompressed while downloading
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionXz   = "xz"
+	// CompressionAuto detects the compression from the first bytes of the downloaded content
+	CompressionAuto = "auto"
+)
+
 // File represents the file instance in directory
 type File struct {
 	Name        string `json:"file_name"`
 	DownloadURL string `json:"download_url"`
+	SHA256      string `json:"sha256,omitempty"`
+	SHA512      string `json:"sha512,omitempty"`
+	// Version is the version of the desired state component of the file
+	Version   string `json:"component_version,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Type      string `json:"type,omitempty"`
+	ExtractTo string `json:"extract_to,omitempty"`
+	// Compression is the compression of the downloaded content, it is taken from the Content-Encoding of the response if not set
+	Compression string `json:"compression,omitempty"`
+
+	SignatureURL   string `json:"signature_url,omitempty"`
+	Signature      string `json:"signature,omitempty"`
+	CertificateURL string `json:"certificate_url,omitempty"`
+	Certificate    string `json:"certificate,omitempty"`
+
+	// PatchURL is the location of a binary patch, which rebuilds the file from the installed file with the BaseSHA256 digest
+	PatchURL   string `json:"patch_url,omitempty"`
+	BaseSHA256 string `json:"base_sha256,omitempty"`
+
+	// HTTPOptions are the HTTP options of the component, e.g. http_auth, which override the ones of the domain configuration
+	HTTPOptions map[string]string `json:"-"`
+
+	// Digest is the digest of the installed content in the form <algorithm>:<hex>, set for current files only
+	Digest string `json:"digest,omitempty"`
+	// Drift is set for current files whose installed content does not match their recorded state
+	Drift string `json:"-"`
+}
+
+// Drift states of current files
+const (
+	// DriftModified denotes a file whose content no longer matches its recorded digest
+	DriftModified = "modified"
+	// DriftMissing denotes a recorded file that no longer exists
+	DriftMissing = "missing"
+	// DriftUnexpected denotes a file that is not recorded at all
+	DriftUnexpected = "unexpected"
+)
+
+// IsSigned checks if a signature is provided for the file
+func (file *File) IsSigned() bool {
+	return file.SignatureURL != "" || file.Signature != ""
+}
+
+// HasPatch checks if a binary patch is provided for the file
+func (file *File) HasPatch() bool {
+	return file.PatchURL != ""
+}
+
+// IsArchive checks if the file is an archive to be extracted
+func (file *File) IsArchive() bool {
+	return file.Type == FileTypeArchive
 }
 
 // AsNamedMap returns a map of file where key is the file's name
@@ -14,6 +14,7 @@ package util
 
 import (
 	"fmt"
+	"strings"
 )
 
 // ActionType defines a type for an action to achieve desired file state
@@ -28,19 +29,64 @@ const (
 	ActionReplace
 	// ActionRemove denotes that the existing file shall be removed from directory
 	ActionRemove
+	// ActionRepair denotes that the existing file has the desired configuration, but its content was modified locally and shall be downloaded again
+	ActionRepair
+	// ActionRename denotes that an existing file under another name has the desired configuration and shall be renamed instead of downloaded again
+	ActionRename
 )
 
-// DetermineUpdateAction compares the current file with the desired one and determines what action shall be done to achieve desired state
+// DetermineUpdateAction compares the current file with the desired one and determines what action shall be done to achieve desired state.
+// The current file can have another name than the desired one, it is renamed then if only the names differ.
 func DetermineUpdateAction(current *File, desired *File) ActionType {
 	if current == nil {
 		return ActionAdd
 	}
-	if current.DownloadURL != desired.DownloadURL && current.Name == desired.Name {
+	if current.Name != desired.Name {
+		if current.Drift == "" && sameContent(current, desired) {
+			return ActionRename
+		}
+		return ActionAdd
+	}
+	if current.DownloadURL != desired.DownloadURL || current.Type != desired.Type || current.ExtractTo != desired.ExtractTo ||
+		current.Compression != desired.Compression {
+		return ActionReplace
+	}
+	// new content can be published under the same URL and version, it is detected by its checksums
+	if !sameChecksums(current, desired) {
+		return ActionReplace
+	}
+	// the version of files recorded by earlier versions of the agent is unknown, it is recorded once the file is identified again
+	if current.Version != "" && current.Version != desired.Version {
 		return ActionReplace
 	}
+	if current.Drift != "" {
+		return ActionRepair
+	}
 	return ActionNone
 }
 
+// sameContent checks if the current file was installed from the same source and with the same configuration as the desired file
+func sameContent(current *File, desired *File) bool {
+	return current.DownloadURL == desired.DownloadURL && current.Type == desired.Type && current.ExtractTo == desired.ExtractTo &&
+		current.Compression == desired.Compression && current.Version == desired.Version &&
+		current.SHA256 == desired.SHA256 && current.SHA512 == desired.SHA512
+}
+
+// sameChecksums checks if the current file matches the checksums of the desired file.
+// The recorded checksums are compared, as well as the digest of the installed content for files other than archives,
+// whose digest covers the extracted tree instead of the archive itself.
+func sameChecksums(current *File, desired *File) bool {
+	if desired.SHA256 != "" {
+		if current.SHA256 != "" && !strings.EqualFold(current.SHA256, desired.SHA256) {
+			return false
+		}
+		if !current.IsArchive() && current.Digest != "" && !strings.EqualFold(current.Digest, "sha256:"+desired.SHA256) {
+			return false
+		}
+	}
+	return desired.SHA512 == "" || current.SHA512 == "" || strings.EqualFold(current.SHA512, desired.SHA512)
+}
+
 // GetActionMessage returns a text message describing the given action type
 func GetActionMessage(actionType ActionType) string {
 	switch actionType {
@@ -52,6 +98,10 @@ func GetActionMessage(actionType ActionType) string {
 		return "Existing file will be replaced by a new one."
 	case ActionRemove:
 		return "Existing file will be removed, no longer needed."
+	case ActionRepair:
+		return "Existing file was modified locally and will be repaired by downloading it again."
+	case ActionRename:
+		return "Existing file will be renamed, its content is kept."
 	}
 	return "Unknown action type: " + fmt.Sprint(actionType)
 }
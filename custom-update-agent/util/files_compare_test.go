@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testSHA256      = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	otherTestSHA256 = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+)
+
+func TestDetermineUpdateAction(t *testing.T) {
+	file := func(modify func(*File)) *File {
+		f := &File{Name: "app.bin", DownloadURL: "https://example.com/app.bin", Version: "1"}
+		if modify != nil {
+			modify(f)
+		}
+		return f
+	}
+	tests := []struct {
+		name    string
+		current *File
+		desired *File
+		action  ActionType
+	}{
+		{name: "new file", desired: file(nil), action: ActionAdd},
+		{name: "unchanged", current: file(nil), desired: file(nil), action: ActionNone},
+		{name: "changed url", current: file(nil), desired: file(func(f *File) { f.DownloadURL = "https://example.com/v2/app.bin" }), action: ActionReplace},
+		{name: "changed version", current: file(nil), desired: file(func(f *File) { f.Version = "2" }), action: ActionReplace},
+		{name: "unknown version", current: file(func(f *File) { f.Version = "" }), desired: file(func(f *File) { f.Version = "2" }), action: ActionNone},
+		{name: "changed type", current: file(nil), desired: file(func(f *File) { f.Type = FileTypeArchive; f.ExtractTo = "app" }), action: ActionReplace},
+		{name: "changed compression", current: file(nil), desired: file(func(f *File) { f.Compression = CompressionGzip }), action: ActionReplace},
+		{name: "drifted", current: file(func(f *File) { f.Drift = DriftModified }), desired: file(nil), action: ActionRepair},
+		{
+			name:    "changed sha256",
+			current: file(func(f *File) { f.SHA256 = testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = otherTestSHA256 }),
+			action:  ActionReplace,
+		},
+		{
+			name:    "sha256 in another case",
+			current: file(func(f *File) { f.SHA256 = testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = strings.ToUpper(testSHA256) }),
+			action:  ActionNone,
+		},
+		{
+			name:    "installed digest differs from the new sha256",
+			current: file(func(f *File) { f.Digest = "sha256:" + testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = otherTestSHA256 }),
+			action:  ActionReplace,
+		},
+		{
+			name:    "installed digest matches the new sha256",
+			current: file(func(f *File) { f.Digest = "sha256:" + testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = testSHA256 }),
+			action:  ActionNone,
+		},
+		{
+			name:    "digest of an extracted archive",
+			current: file(func(f *File) { f.Type = FileTypeArchive; f.ExtractTo = "app"; f.Digest = "sha256:" + testSHA256 }),
+			desired: file(func(f *File) { f.Type = FileTypeArchive; f.ExtractTo = "app"; f.SHA256 = otherTestSHA256 }),
+			action:  ActionNone,
+		},
+		{
+			name:    "changed sha512",
+			current: file(func(f *File) { f.SHA512 = strings.Repeat("a", 128) }),
+			desired: file(func(f *File) { f.SHA512 = strings.Repeat("b", 128) }),
+			action:  ActionReplace,
+		},
+		{
+			name:    "renamed",
+			current: file(func(f *File) { f.Name = "old.bin"; f.SHA256 = testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = testSHA256 }),
+			action:  ActionRename,
+		},
+		{
+			name:    "renamed with other content",
+			current: file(func(f *File) { f.Name = "old.bin"; f.SHA256 = testSHA256 }),
+			desired: file(func(f *File) { f.SHA256 = otherTestSHA256 }),
+			action:  ActionAdd,
+		},
+		{
+			name:    "renamed but drifted",
+			current: file(func(f *File) { f.Name = "old.bin"; f.Drift = DriftModified }),
+			desired: file(nil),
+			action:  ActionAdd,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if action := DetermineUpdateAction(test.current, test.desired); action != test.action {
+				t.Errorf("expected action %s, got %s", GetActionMessage(test.action), GetActionMessage(action))
+			}
+		})
+	}
+}
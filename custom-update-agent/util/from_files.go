@@ -29,10 +29,18 @@ func fromFile(file *File) *types.SoftwareNode {
 	params := []*types.KeyValuePair{}
 
 	params = append(params, &types.KeyValuePair{Key: "download_url", Value: file.DownloadURL})
+	if file.IsArchive() {
+		params = append(params, &types.KeyValuePair{Key: "type", Value: file.Type})
+		params = append(params, &types.KeyValuePair{Key: "extract_to", Value: file.ExtractTo})
+	}
+	if file.Drift != "" {
+		params = append(params, &types.KeyValuePair{Key: "drift", Value: file.Drift})
+	}
 
 	return &types.SoftwareNode{
 		InventoryNode: types.InventoryNode{
 			ID:         file.Name,
+			Version:    file.Version,
 			Parameters: params,
 		},
 		Type: types.SoftwareTypeData,
@@ -13,6 +13,13 @@
 package util
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"path/filepath"
+	"strconv"
+	"strings"
+
 	"github.com/eclipse-kanto/update-manager/api/types"
 	"github.com/pkg/errors"
 )
@@ -31,7 +38,7 @@ func ToFiles(components []*types.ComponentWithConfig) ([]*File, error) {
 }
 
 func toFile(component *types.ComponentWithConfig) (*File, error) {
-	file := &File{}
+	file := &File{Version: component.Version}
 	for _, kvPair := range component.Config {
 		if kvPair.Key == "file_name" {
 			file.Name = kvPair.Value
@@ -39,6 +46,138 @@ func toFile(component *types.ComponentWithConfig) (*File, error) {
 		if kvPair.Key == "download_url" {
 			file.DownloadURL = kvPair.Value
 		}
+		if kvPair.Key == "sha256" {
+			file.SHA256 = strings.ToLower(kvPair.Value)
+		}
+		if kvPair.Key == "sha512" {
+			file.SHA512 = strings.ToLower(kvPair.Value)
+		}
+		if kvPair.Key == "type" {
+			file.Type = kvPair.Value
+		}
+		if kvPair.Key == "extract_to" {
+			file.ExtractTo = kvPair.Value
+		}
+		if kvPair.Key == "compression" {
+			file.Compression = strings.ToLower(kvPair.Value)
+		}
+		if kvPair.Key == "signature_url" {
+			file.SignatureURL = kvPair.Value
+		}
+		if kvPair.Key == "signature" {
+			file.Signature = kvPair.Value
+		}
+		if kvPair.Key == "certificate_url" {
+			file.CertificateURL = kvPair.Value
+		}
+		if kvPair.Key == "certificate" {
+			file.Certificate = kvPair.Value
+		}
+		if kvPair.Key == "patch_url" {
+			file.PatchURL = kvPair.Value
+		}
+		if kvPair.Key == "base_sha256" {
+			file.BaseSHA256 = strings.ToLower(kvPair.Value)
+		}
+		if strings.HasPrefix(kvPair.Key, "http_") {
+			if file.HTTPOptions == nil {
+				file.HTTPOptions = map[string]string{}
+			}
+			file.HTTPOptions[kvPair.Key] = kvPair.Value
+		}
+		if kvPair.Key == "size" {
+			size, err := strconv.ParseInt(kvPair.Value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, errors.Errorf("size must be a non-negative number, but got %s", kvPair.Value)
+			}
+			file.Size = size
+		}
+	}
+	if file.Name == "" || file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+		return nil, errors.Errorf("file_name must be a plain file name, but got %s", file.Name)
+	}
+	if err := validateType(file); err != nil {
+		return nil, err
+	}
+	if err := validateChecksum("sha256", file.SHA256, sha256.Size); err != nil {
+		return nil, err
+	}
+	if err := validateChecksum("sha512", file.SHA512, sha512.Size); err != nil {
+		return nil, err
+	}
+	if err := validatePatch(file); err != nil {
+		return nil, err
+	}
+	if err := validateCompression(file); err != nil {
+		return nil, err
 	}
 	return file, nil
 }
+
+func validateChecksum(key string, value string, size int) error {
+	if value == "" {
+		return nil
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return errors.Wrapf(err, "%s checksum is not a valid hex string", key)
+	}
+	if len(decoded) != size {
+		return errors.Errorf("%s checksum must be %d bytes long, but got %d", key, size, len(decoded))
+	}
+	return nil
+}
+
+func validatePatch(file *File) error {
+	if !file.HasPatch() {
+		if file.BaseSHA256 != "" {
+			return errors.New("base_sha256 is supported only together with patch_url")
+		}
+		return nil
+	}
+	if file.IsArchive() {
+		return errors.New("patch_url is not supported for archives")
+	}
+	if file.BaseSHA256 == "" {
+		return errors.New("base_sha256 is required for patches")
+	}
+	if file.SHA256 == "" && file.SHA512 == "" {
+		return errors.New("sha256 or sha512 checksum of the patched file is required for patches")
+	}
+	return validateChecksum("base_sha256", file.BaseSHA256, sha256.Size)
+}
+
+func validateType(file *File) error {
+	switch file.Type {
+	case "", "file":
+		file.Type = ""
+		if file.ExtractTo != "" {
+			return errors.New("extract_to is supported only for archives")
+		}
+	case FileTypeArchive:
+		if file.ExtractTo == "" {
+			return errors.New("extract_to is required for archives")
+		}
+		cleaned := filepath.Clean(file.ExtractTo)
+		if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+			return errors.Errorf("extract_to must be a subdirectory of the managed directory, but got %s", file.ExtractTo)
+		}
+		file.ExtractTo = cleaned
+	default:
+		return errors.Errorf("unsupported file type %s", file.Type)
+	}
+	return nil
+}
+
+func validateCompression(file *File) error {
+	switch file.Compression {
+	case "":
+		return nil
+	case CompressionNone, CompressionGzip, CompressionZstd, CompressionXz, CompressionAuto:
+		if file.IsArchive() {
+			return errors.New("compression is not supported for archives, they are decompressed while extracted")
+		}
+		return nil
+	}
+	return errors.Errorf("compression must be %s, %s, %s, %s or %s, but got %s", CompressionNone, CompressionGzip, CompressionZstd, CompressionXz, CompressionAuto, file.Compression)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Contributors to the Eclipse Foundation
+//
+// See the NOTICE file(s) distributed with this work for additional
+// information regarding copyright ownership.
+//
+// This program and the accompanying materials are made available under the
+// terms of the Eclipse Public License 2.0 which is available at
+// https://www.eclipse.org/legal/epl-2.0, or the Apache License, Version 2.0
+// which is available at https://www.apache.org/licenses/LICENSE-2.0.
+//
+// SPDX-License-Identifier: EPL-2.0 OR Apache-2.0
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eclipse-kanto/update-manager/api/types"
+)
+
+func component(config ...string) *types.ComponentWithConfig {
+	c := &types.ComponentWithConfig{Component: types.Component{ID: "app", Version: "1"}}
+	for i := 0; i+1 < len(config); i += 2 {
+		c.Config = append(c.Config, &types.KeyValuePair{Key: config[i], Value: config[i+1]})
+	}
+	return c
+}
+
+func TestToFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		component *types.ComponentWithConfig
+		check     func(*File) bool
+		err       string
+	}{
+		{
+			name:      "plain file",
+			component: component("file_name", "app.bin", "download_url", "https://example.com/app.bin", "size", "42"),
+			check:     func(f *File) bool { return f.Name == "app.bin" && f.Version == "1" && f.Size == 42 && f.Type == "" },
+		},
+		{name: "missing file name", component: component("download_url", "https://example.com/app.bin"), err: "file_name must be a plain file name"},
+		{name: "file name with directory", component: component("file_name", "../app.bin"), err: "file_name must be a plain file name"},
+		{name: "negative size", component: component("file_name", "app.bin", "size", "-1"), err: "size must be a non-negative number"},
+		{
+			name:      "archive",
+			component: component("file_name", "app.tar.gz", "type", "archive", "extract_to", "app/./lib"),
+			check:     func(f *File) bool { return f.IsArchive() && f.ExtractTo == "app/lib" },
+		},
+		{name: "archive without extract_to", component: component("file_name", "app.tar.gz", "type", "archive"), err: "extract_to is required"},
+		{name: "archive extracted outside", component: component("file_name", "app.tar.gz", "type", "archive", "extract_to", "../app"), err: "must be a subdirectory"},
+		{name: "archive extracted to the root", component: component("file_name", "app.tar.gz", "type", "archive", "extract_to", "."), err: "must be a subdirectory"},
+		{name: "extract_to for a file", component: component("file_name", "app.bin", "extract_to", "app"), err: "extract_to is supported only for archives"},
+		{name: "unsupported type", component: component("file_name", "app.bin", "type", "image"), err: "unsupported file type"},
+		{name: "invalid sha256", component: component("file_name", "app.bin", "sha256", "xyz"), err: "not a valid hex string"},
+		{name: "short sha256", component: component("file_name", "app.bin", "sha256", "abcd"), err: "must be 32 bytes long"},
+		{name: "short sha512", component: component("file_name", "app.bin", "sha512", testSHA256), err: "must be 64 bytes long"},
+		{
+			name:      "patch",
+			component: component("file_name", "app.bin", "sha256", testSHA256, "patch_url", "https://example.com/app.patch", "base_sha256", otherTestSHA256),
+			check:     func(f *File) bool { return f.HasPatch() && f.BaseSHA256 == otherTestSHA256 },
+		},
+		{name: "patch without base", component: component("file_name", "app.bin", "sha256", testSHA256, "patch_url", "https://example.com/app.patch"), err: "base_sha256 is required"},
+		{name: "patch without checksum", component: component("file_name", "app.bin", "patch_url", "https://example.com/app.patch", "base_sha256", testSHA256), err: "checksum of the patched file is required"},
+		{name: "base without patch", component: component("file_name", "app.bin", "base_sha256", testSHA256), err: "supported only together with patch_url"},
+		{
+			name:      "patch of an archive",
+			component: component("file_name", "app.tar", "type", "archive", "extract_to", "app", "sha256", testSHA256, "patch_url", "https://example.com/app.patch", "base_sha256", testSHA256),
+			err:       "not supported for archives",
+		},
+		{name: "compression", component: component("file_name", "app.bin", "compression", "ZSTD"), check: func(f *File) bool { return f.Compression == CompressionZstd }},
+		{name: "auto compression", component: component("file_name", "app.bin", "compression", "auto"), check: func(f *File) bool { return f.Compression == CompressionAuto }},
+		{name: "unsupported compression", component: component("file_name", "app.bin", "compression", "brotli"), err: "compression must be"},
+		{name: "compression of an archive", component: component("file_name", "app.tar", "type", "archive", "extract_to", "app", "compression", "auto"), err: "not supported for archives"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := ToFiles([]*types.ComponentWithConfig{test.component})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || !test.check(files[0]) {
+				t.Errorf("unexpected file %+v", files[0])
+			}
+		})
+	}
+}